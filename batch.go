@@ -0,0 +1,196 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// WBatch is a batch of queries sent in one round trip, see WBatcher.
+// Unlike pgx.Batch, every queued query carries its own name for metrics and tracing.
+type WBatch struct {
+	batch pgx.Batch
+	names []string
+}
+
+// Queue queues a query named @p name. Results must be read from the pgx.BatchResults returned
+// by WSendBatch, in the same order as the queries were queued.
+func (b *WBatch) Queue(name string, unprepared string, args ...interface{}) {
+	b.names = append(b.names, name)
+	b.batch.Queue(unprepared, args...)
+}
+
+// Len returns the number of queued queries.
+func (b *WBatch) Len() int {
+	return len(b.names)
+}
+
+// wBatchResults is a pgx.BatchResults that observes every query when its result is read,
+// and ends the span of the batch when closed.
+type wBatchResults struct {
+	ctx     context.Context
+	results pgx.BatchResults
+	// release, if set, is called on Close to release the connection of the batch.
+	release     func()
+	names       []string
+	queries     []*pgx.QueuedQuery
+	next        int
+	stats       MetricsRecorder
+	tracer      *tracer
+	logger      *queryLogger
+	replicaName *ReplicaName
+	startedAt   time.Time
+	// err is the first error of the queries, recorded on the span of the batch.
+	err    error
+	closed bool
+	mutex  sync.Mutex
+}
+
+var _ pgx.BatchResults = (*wBatchResults)(nil)
+
+// errBatchResults is a pgx.BatchResults whose results are all err, e.g. when no connection
+// could be acquired for the batch.
+type errBatchResults struct {
+	err error
+}
+
+func (r errBatchResults) Exec() (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, r.err
+}
+
+func (r errBatchResults) Query() (pgx.Rows, error) {
+	return errRows(r), r.err
+}
+
+func (r errBatchResults) QueryRow() pgx.Row {
+	return errRow(r)
+}
+
+func (r errBatchResults) Close() error {
+	return r.err
+}
+
+func newWBatchResults(
+	ctx context.Context, results pgx.BatchResults, batch *WBatch,
+	stats MetricsRecorder, tracer *tracer, logger *queryLogger, replicaName *ReplicaName, startedAt time.Time,
+) *wBatchResults {
+	return &wBatchResults{
+		ctx:         ctx,
+		results:     results,
+		names:       batch.names,
+		queries:     batch.batch.QueuedQueries,
+		stats:       stats,
+		tracer:      tracer,
+		logger:      logger,
+		replicaName: replicaName,
+		startedAt:   startedAt,
+	}
+}
+
+// advance returns the index of the query whose result is read next, -1 if all results were read.
+func (r *wBatchResults) advance() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.next >= len(r.names) {
+		// reading more results than queued, pgx will return an error.
+		return -1
+	}
+	r.next++
+	return r.next - 1
+}
+
+// observe observes the i-th query, which returned or affected @p rows rows, unless negative.
+func (r *wBatchResults) observe(i int, rows int64, err error) {
+	if i < 0 {
+		return
+	}
+	name := r.names[i]
+	if r.stats != nil {
+		r.stats.MakeObserver(name, r.replicaName, r.startedAt, &err)()
+	}
+	if r.logger != nil {
+		r.logger.Log(r.ctx, name, r.replicaName, r.queries[i].SQL, r.startedAt, rows, err)
+	}
+	if r.tracer != nil {
+		r.tracer.TraceBatchQuery(r.ctx, name, err)
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.err == nil {
+			r.err = err
+		}
+	}
+}
+
+func (r *wBatchResults) Exec() (pgconn.CommandTag, error) {
+	i := r.advance()
+	cmd, err := r.results.Exec()
+	r.observe(i, cmd.RowsAffected(), err)
+	if err == nil && i >= 0 && r.stats != nil {
+		r.stats.ObserveRowsAffected(r.names[i], r.replicaName, cmd.RowsAffected())
+	}
+	return cmd, err
+}
+
+func (r *wBatchResults) Query() (pgx.Rows, error) {
+	i := r.advance()
+	rows, err := r.results.Query()
+	if err != nil || i < 0 {
+		r.observe(i, -1, err)
+		return rows, err
+	}
+	// observed when the rows are closed, which must happen before reading the next result.
+	return &observedRows{
+		Rows: rows,
+		done: func(n int64, err error) {
+			r.observe(i, n, err)
+			if r.stats != nil {
+				r.stats.ObserveRowsReturned(r.names[i], r.replicaName, n)
+			}
+		},
+	}, nil
+}
+
+func (r *wBatchResults) QueryRow() pgx.Row {
+	i := r.advance()
+	return &observedRow{
+		row: r.results.QueryRow(),
+		done: func(err error) {
+			r.observe(i, -1, err)
+		},
+	}
+}
+
+// Close closes the batch results. Queries whose results were not read are observed
+// with the error of Close, if any.
+func (r *wBatchResults) Close() error {
+	err := r.results.Close()
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return err
+	}
+	r.closed = true
+	if r.release != nil {
+		r.release()
+	}
+	unread := r.next
+	r.next = len(r.names)
+	r.mutex.Unlock()
+	for i := unread; i < len(r.names); i++ {
+		r.observe(i, -1, err)
+	}
+	if r.tracer != nil {
+		spanErr := r.err
+		if spanErr == nil {
+			spanErr = err
+		}
+		r.tracer.TraceEnd(r.ctx, &spanErr)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeBatchResults returns errs in order for each result.
+type fakeBatchResults struct {
+	errs     []error
+	next     int
+	closeErr error
+}
+
+func (r *fakeBatchResults) pop() error {
+	err := r.errs[r.next]
+	r.next++
+	return err
+}
+
+func (r *fakeBatchResults) Exec() (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, r.pop()
+}
+
+func (r *fakeBatchResults) Query() (pgx.Rows, error) {
+	return nil, r.pop()
+}
+
+func (r *fakeBatchResults) QueryRow() pgx.Row {
+	return errRow{err: r.pop()}
+}
+
+func (r *fakeBatchResults) Close() error {
+	return r.closeErr
+}
+
+type BatchTestSuite struct {
+	suite.Suite
+}
+
+func TestBatchTestSuite(t *testing.T) {
+	suite.Run(t, new(BatchTestSuite))
+}
+
+func (suite *BatchTestSuite) TestObservePerQuery() {
+	stats := newMetricSet("batch_test", metricOptions{})
+	batch := &WBatch{}
+	batch.Queue("insert", "INSERT INTO t VALUES (1)")
+	batch.Queue("select_row", "SELECT 1")
+	batch.Queue("select", "SELECT 1")
+	batch.Queue("unread", "SELECT 1")
+	suite.Equal(4, batch.Len())
+
+	closeErr := errors.New("close")
+	results := newWBatchResults(context.Background(),
+		&fakeBatchResults{errs: []error{nil, pgx.ErrNoRows, errors.New("failed")}, closeErr: closeErr},
+		batch, stats, nil, nil, nil, time.Now())
+	_, err := results.Exec()
+	suite.NoError(err)
+	suite.ErrorIs(results.QueryRow().Scan(), pgx.ErrNoRows)
+	_, err = results.Query()
+	suite.Error(err)
+	suite.ErrorIs(results.Close(), closeErr)
+
+	for _, name := range []string{"insert", "select_row", "select", "unread"} {
+		suite.Equal(1.0, testutil.ToFloat64(stats.Request.WithLabelValues("batch_test", name, "primary")), name)
+	}
+	suite.Equal(0.0, testutil.ToFloat64(stats.Error.WithLabelValues("batch_test", "insert", "primary")))
+	// no rows is not an error.
+	suite.Equal(0.0, testutil.ToFloat64(stats.Error.WithLabelValues("batch_test", "select_row", "primary")))
+	suite.Equal(1.0, testutil.ToFloat64(stats.NoRows.WithLabelValues("batch_test", "select_row", "primary")))
+	suite.Equal(1.0, testutil.ToFloat64(stats.Error.WithLabelValues("batch_test", "select", "primary")))
+	suite.Equal(1.0, testutil.ToFloat64(stats.Error.WithLabelValues("batch_test", "unread", "primary")))
+
+	// closing again does not observe twice.
+	suite.ErrorIs(results.Close(), closeErr)
+	suite.Equal(1.0, testutil.ToFloat64(stats.Request.WithLabelValues("batch_test", "unread", "primary")))
+}
+
+func (suite *BatchTestSuite) TestErrBatchResults() {
+	failed := errors.New("failed")
+	results := errBatchResults{err: failed}
+	rows, err := results.Query()
+	suite.Equal(failed, err)
+	// the rows are safe to read, as with pgx.
+	suite.Require().NotNil(rows)
+	suite.False(rows.Next())
+	suite.Equal(failed, rows.Err())
+	rows.Close()
+	suite.Equal(failed, results.QueryRow().Scan())
+}
@@ -3,11 +3,18 @@ package wpgx
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -32,6 +39,17 @@ type ReadReplicaConfig struct {
 	IsProxy       bool                                  `default:"false"`
 	Broken        bool                                  `default:"false"`
 	SSLMode       string                                `default:"disable"`
+	// Weight is the relative weight of this replica in replica groups using the
+	// WeightedRandom strategy. Zero is treated as 1.
+	Weight int `default:"1"`
+}
+
+// ReplicaGroupConfig is the configuration of a named group of read replicas that
+// serve the same data, see Pool.WQuerierGroup.
+type ReplicaGroupConfig struct {
+	Name     ReplicaGroupName    `required:"true"`
+	Members  []ReplicaName       `required:"true"`
+	Strategy LoadBalanceStrategy `default:"round_robin"`
 }
 
 // Config is the configuration for the WPgx.
@@ -56,12 +74,108 @@ type Config struct {
 	EnablePrometheus bool   `default:"true"`
 	EnableTracing    bool   `default:"true"`
 	AppName          string `required:"true"`
+	// LegacyTraceSemconv makes spans carry the attributes of semconv v1.11.0, i.e. the query
+	// name in db.statement and the replica in db.connection_string, instead of the current
+	// database semantic conventions.
+	LegacyTraceSemconv bool `default:"false"`
+	// TraceSQL records the SQL text of queries in spans, as db.query.text, with whitespaces
+	// collapsed and truncated to TraceSQLMaxLength bytes.
+	TraceSQL          bool `default:"false"`
+	TraceSQLMaxLength int  `default:"2048"`
+	// TraceArgs records the arguments of queries in spans, as db.query.parameter.<i>. Arguments
+	// may contain sensitive data, mask them with TraceArgsRedactor, or enable it in non-prod only.
+	TraceArgs bool `default:"false"`
+	// TraceArgsRedactor, if set, transforms the arguments before they are recorded, see
+	// RedactArgsAt and RedactArgsOfType.
+	TraceArgsRedactor ArgRedactor `ignored:"true"`
+	// TracerProvider provides the tracer of spans, the global one if nil.
+	TracerProvider trace.TracerProvider `ignored:"true"`
+	// TraceAttributes are added to all spans, e.g. the service or the shard.
+	TraceAttributes []attribute.KeyValue `ignored:"true"`
+	// SpanNameFormatter, if set, names the spans instead of the query names.
+	SpanNameFormatter SpanNameFunc `ignored:"true"`
+	// SpanAttributes, if set, adds attributes to every span, e.g. from the context.
+	SpanAttributes SpanAttributesFunc `ignored:"true"`
+	// TraceRootSpans creates spans for queries without a recording parent span, e.g. from
+	// background jobs. By default, queries are only traced as part of an existing trace.
+	TraceRootSpans bool `default:"false"`
+	// EnableOTelMetrics records metrics with OpenTelemetry, in addition to Prometheus if
+	// EnablePrometheus is set as well.
+	EnableOTelMetrics bool `default:"false"`
+	// MeterProvider provides the meter of OpenTelemetry metrics, the global one if nil.
+	MeterProvider metric.MeterProvider `ignored:"true"`
+	// MetricsRecorder, if set, records the metrics instead of the built-in Prometheus and
+	// OpenTelemetry recorders, and EnablePrometheus and EnableOTelMetrics are ignored.
+	MetricsRecorder MetricsRecorder `ignored:"true"`
+	// PrometheusRegisterer is the registerer of the metrics, prometheus.DefaultRegisterer if nil.
+	PrometheusRegisterer prometheus.Registerer `ignored:"true"`
+	// PrometheusConstLabels are added to all metrics, e.g. db:orders, to tell apart pools of the
+	// same app. Pools sharing the AppName and the labels share the metrics.
+	PrometheusConstLabels map[string]string `default:""`
+	// LatencyBuckets overrides the buckets of latency histograms, in seconds, or in milliseconds
+	// if LegacyMillisecondLatency is set.
+	LatencyBuckets []float64 `default:""`
+	// LegacyMillisecondLatency keeps latency histograms in milliseconds, named *_milliseconds,
+	// for dashboards built before they were switched to seconds.
+	LegacyMillisecondLatency bool `default:"false"`
+	// NativeHistogramBucketFactor enables Prometheus native histograms, in addition to the classic
+	// buckets, when > 1. The smaller the factor, the higher the resolution, e.g. 1.1.
+	NativeHistogramBucketFactor float64 `default:"0"`
+	// NativeHistogramMaxBucketNumber limits the number of buckets of native histograms.
+	NativeHistogramMaxBucketNumber uint32 `default:"160"`
+	// LogQueries logs every query of WConn and WTx, with its name, replica, duration, rows,
+	// SQLSTATE and trace id, at QueryLogLevel, or QueryErrorLogLevel if it failed.
+	// QueryErrorLogLevel cannot be debug, its zero value, which means error.
+	LogQueries         bool          `default:"false"`
+	QueryLogLevel      zerolog.Level `default:"debug"`
+	QueryErrorLogLevel zerolog.Level `default:"error"`
+	// SlowQueryThreshold, if positive, logs queries taking longer, at least at WARN and with
+	// their SQL text, even if LogQueries is not set.
+	SlowQueryThreshold time.Duration `default:"0"`
+	// QueryLogHandler, if set, receives the query logs instead of the global zerolog logger.
+	QueryLogHandler slog.Handler `ignored:"true"`
 
 	// ReplicaConfigPrefixes is a list of replica configuration prefixes. They will
 	// be used to create ReadReplicas by using envconfig to parse them.
 	ReplicaPrefixes []string `default:""`
 	// ReadReplicas is a list of read replicas, parsed from ReplicaNames.
 	ReadReplicas []ReadReplicaConfig `ignored:"true"`
+	// ReplicaGroupPrefixes is a list of replica group configuration prefixes. They will
+	// be used to create ReplicaGroups by using envconfig to parse them.
+	ReplicaGroupPrefixes []string `default:""`
+	// ReplicaGroups is a list of replica groups, parsed from ReplicaGroupPrefixes.
+	ReplicaGroups []ReplicaGroupConfig `ignored:"true"`
+	// MaxReplicaLag is the maximum replication lag tolerated by WQuerier. When the requested
+	// replica lags behind the primary by more than MaxReplicaLag, or its lag is unknown,
+	// the primary is used instead. Zero disables lag-aware routing.
+	MaxReplicaLag time.Duration `default:"0"`
+	// ReplicaLagCheckInterval is the interval of sampling the replication lag of replicas, on
+	// a dedicated connection to each replica and to the primary. The sampling only starts once
+	// the lag is used, i.e. when MaxReplicaLag is set, on the first call of WQuerierMaxLag with
+	// a positive maximum lag, or on the first query carrying a ConsistencyToken. Zero disables
+	// the sampling, and so lag-aware routing; queries carrying a token then use the primary.
+	ReplicaLagCheckInterval time.Duration `default:"1s"`
+	// HealthCheckInterval, if positive, enables health checking: replicas are pinged at this
+	// interval, on a dedicated connection to each replica, and marked as broken at runtime when
+	// they fail to respond, e.g. 5s. Zero, the default, disables it, replicas are then only
+	// broken by ReadReplicaConfig.Broken.
+	HealthCheckInterval time.Duration `default:"0"`
+	// HealthCheckFailureThreshold is the number of consecutive failed pings before a replica
+	// is marked as broken, after which WQuerier routes to the primary instead.
+	HealthCheckFailureThreshold int `default:"3"`
+	// HealthCheckRecoveryThreshold is the number of consecutive successful pings before a
+	// broken replica is restored.
+	HealthCheckRecoveryThreshold int `default:"1"`
+	// PostExecMode determines how PostExec functions of a transaction are run after commit.
+	PostExecMode PostExecMode `default:"concurrent"`
+	// PostExecConcurrency is the number of workers running PostExec functions in PostExecBounded mode.
+	PostExecConcurrency int `default:"4"`
+	// PostExecReturnError makes Transact return the errors of failed PostExec functions, wrapped
+	// in ErrPostExec, along with the response. The transaction is committed nonetheless.
+	PostExecReturnError bool `default:"false"`
+	// RecoverTxPanic makes Transact recover panics in the transaction body and return them
+	// as *TxPanicError, instead of re-panicking after the transaction is rolled back.
+	RecoverTxPanic bool `default:"false"`
 }
 
 func (c *Config) Valid() error {
@@ -88,8 +202,66 @@ func (c *Config) Valid() error {
 		if _, ok := showedNames[replica.Name]; ok {
 			return fmt.Errorf("duplicated ReadReplicas[%d].Name: %s", i, c)
 		}
+		if replica.Weight < 0 {
+			return fmt.Errorf("ReadReplicas[%d].Weight must >= 0: %s", i, c)
+		}
 		showedNames[replica.Name] = true
 	}
+	showedGroups := make(map[ReplicaGroupName]bool)
+	for i, group := range c.ReplicaGroups {
+		if len(group.Name) == 0 {
+			return fmt.Errorf("invalid ReplicaGroups[%d].Name: %s", i, c)
+		}
+		if _, ok := showedGroups[group.Name]; ok {
+			return fmt.Errorf("duplicated ReplicaGroups[%d].Name: %s", i, c)
+		}
+		showedGroups[group.Name] = true
+		if len(group.Members) == 0 {
+			return fmt.Errorf("ReplicaGroups[%d].Members cannot be empty: %s", i, c)
+		}
+		for _, member := range group.Members {
+			if !showedNames[member] {
+				return fmt.Errorf("ReplicaGroups[%d] has unknown member %s: %s", i, member, c)
+			}
+		}
+		if !group.Strategy.valid() {
+			return fmt.Errorf("ReplicaGroups[%d] has invalid strategy %q: %s", i, group.Strategy, c)
+		}
+	}
+	if c.SlowQueryThreshold < 0 {
+		return fmt.Errorf("SlowQueryThreshold must >= 0: %s", c)
+	}
+	if c.MaxReplicaLag < 0 {
+		return fmt.Errorf("MaxReplicaLag must >= 0: %s", c)
+	}
+	if c.MaxReplicaLag > 0 && c.ReplicaLagCheckInterval <= 0 {
+		return fmt.Errorf("ReplicaLagCheckInterval must > 0 when MaxReplicaLag is set: %s", c)
+	}
+	for name := range c.PrometheusConstLabels {
+		if slices.Contains(reservedLabels, name) {
+			return fmt.Errorf("PrometheusConstLabels cannot contain reserved label %q: %s", name, c)
+		}
+	}
+	for i := range c.LatencyBuckets {
+		if c.LatencyBuckets[i] <= 0 || (i > 0 && c.LatencyBuckets[i] <= c.LatencyBuckets[i-1]) {
+			return fmt.Errorf("LatencyBuckets must be positive and increasing: %s", c)
+		}
+	}
+	if c.NativeHistogramBucketFactor != 0 && c.NativeHistogramBucketFactor <= 1 {
+		return fmt.Errorf("NativeHistogramBucketFactor must be 0 or > 1: %s", c)
+	}
+	if c.TraceSQLMaxLength < 0 {
+		return fmt.Errorf("TraceSQLMaxLength must >= 0: %s", c)
+	}
+	if !c.PostExecMode.valid() {
+		return fmt.Errorf("invalid PostExecMode %q: %s", c.PostExecMode, c)
+	}
+	if c.PostExecMode == PostExecBounded && c.PostExecConcurrency <= 0 {
+		return fmt.Errorf("PostExecConcurrency must > 0 in %s mode: %s", PostExecBounded, c)
+	}
+	if c.HealthCheckInterval > 0 && (c.HealthCheckFailureThreshold <= 0 || c.HealthCheckRecoveryThreshold <= 0) {
+		return fmt.Errorf("HealthCheck thresholds must > 0 when HealthCheckInterval is set: %s", c)
+	}
 	return nil
 }
 
@@ -119,6 +291,11 @@ func ConfigFromEnvPrefix(prefix string) *Config {
 		envconfig.MustProcess(prefix, &replicaConfig)
 		config.ReadReplicas = append(config.ReadReplicas, replicaConfig)
 	}
+	for _, prefix := range config.ReplicaGroupPrefixes {
+		groupConfig := ReplicaGroupConfig{}
+		envconfig.MustProcess(prefix, &groupConfig)
+		config.ReplicaGroups = append(config.ReplicaGroups, groupConfig)
+	}
 	if err := config.Valid(); err != nil {
 		log.Fatal().Msgf("%s", err)
 	}
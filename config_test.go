@@ -2,7 +2,9 @@ package wpgx
 
 import (
 	"testing"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -21,6 +23,7 @@ func (suite *ConfigTestSuite) TestDefaultConfigParse() {
 	suite.T().Setenv("POSTGRES_APPNAME", "test")
 	config := ConfigFromEnv()
 	suite.Equal(0, len(config.ReadReplicas))
+	suite.Zero(config.HealthCheckInterval, "health checking is opt-in")
 }
 
 func (suite *ConfigTestSuite) TestDefaultConfigParse2Replica() {
@@ -31,3 +34,57 @@ func (suite *ConfigTestSuite) TestDefaultConfigParse2Replica() {
 	config := ConfigFromEnv()
 	suite.Equal(2, len(config.ReadReplicas))
 }
+
+func (suite *ConfigTestSuite) TestConfigParseReplicaGroups() {
+	suite.T().Setenv("POSTGRES_APPNAME", "test")
+	suite.T().Setenv("POSTGRES_REPLICAPREFIXES", "R1,R2")
+	suite.T().Setenv("R1_NAME", "r1")
+	suite.T().Setenv("R2_NAME", "r2")
+	suite.T().Setenv("R2_WEIGHT", "3")
+	suite.T().Setenv("POSTGRES_REPLICAGROUPPREFIXES", "ANALYTICS")
+	suite.T().Setenv("ANALYTICS_NAME", "analytics")
+	suite.T().Setenv("ANALYTICS_MEMBERS", "r1,r2")
+	suite.T().Setenv("ANALYTICS_STRATEGY", "weighted_random")
+	config := ConfigFromEnv()
+	suite.Equal(3, config.ReadReplicas[1].Weight)
+	suite.Equal([]ReplicaGroupConfig{{
+		Name:     "analytics",
+		Members:  []ReplicaName{"r1", "r2"},
+		Strategy: WeightedRandom,
+	}}, config.ReplicaGroups)
+
+	config.ReplicaGroups[0].Members = append(config.ReplicaGroups[0].Members, "r3")
+	suite.Error(config.Valid())
+	config.ReplicaGroups[0].Members = []ReplicaName{"r1"}
+	config.ReplicaGroups[0].Strategy = "random"
+	suite.Error(config.Valid())
+}
+
+func (suite *ConfigTestSuite) TestConfigParseConstLabels() {
+	suite.T().Setenv("POSTGRES_APPNAME", "test")
+	suite.T().Setenv("POSTGRES_PROMETHEUSCONSTLABELS", "db:orders,region:us")
+	config := ConfigFromEnv()
+	suite.Equal(map[string]string{"db": "orders", "region": "us"}, config.PrometheusConstLabels)
+
+	config.PrometheusConstLabels["replica"] = "r1"
+	suite.Error(config.Valid())
+}
+
+func (suite *ConfigTestSuite) TestConfigParseQueryLog() {
+	suite.T().Setenv("POSTGRES_APPNAME", "test")
+	config := ConfigFromEnv()
+	suite.False(config.LogQueries)
+	suite.Equal(zerolog.DebugLevel, config.QueryLogLevel)
+	suite.Equal(zerolog.ErrorLevel, config.QueryErrorLogLevel)
+
+	suite.T().Setenv("POSTGRES_LOGQUERIES", "true")
+	suite.T().Setenv("POSTGRES_QUERYLOGLEVEL", "info")
+	suite.T().Setenv("POSTGRES_SLOWQUERYTHRESHOLD", "500ms")
+	config = ConfigFromEnv()
+	suite.True(config.LogQueries)
+	suite.Equal(zerolog.InfoLevel, config.QueryLogLevel)
+	suite.Equal(500*time.Millisecond, config.SlowQueryThreshold)
+
+	config.SlowQueryThreshold = -time.Second
+	suite.Error(config.Valid())
+}
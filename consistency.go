@@ -0,0 +1,88 @@
+package wpgx
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const currentWalLSNQuery = `SELECT pg_current_wal_lsn()::text`
+
+// ConsistencyToken is a WAL LSN of the primary, obtained after a write, see
+// Pool.TransactWithToken. Reads that carry the token in their context, see
+// WithConsistencyToken, are only served by replicas that have replayed the WAL up to the
+// token, so that they can see the write.
+type ConsistencyToken uint64
+
+// String formats the token in the PostgreSQL pg_lsn format, e.g. 16/B374D848.
+func (t ConsistencyToken) String() string {
+	return fmt.Sprintf("%X/%X", uint32(t>>32), uint32(t)) // #nosec G115 -- splitting the LSN into halves.
+}
+
+// ParseConsistencyToken parses a token in the PostgreSQL pg_lsn format, e.g. 16/B374D848.
+func ParseConsistencyToken(s string) (ConsistencyToken, error) {
+	var hi, lo uint32
+	if _, err := fmt.Sscanf(s, "%X/%X", &hi, &lo); err != nil {
+		return 0, fmt.Errorf("invalid consistency token %q: %w", s, err)
+	}
+	return ConsistencyToken(uint64(hi)<<32 | uint64(lo)), nil
+}
+
+type consistencyTokenKey struct{}
+
+// WithConsistencyToken returns a context carrying the token. Queries using the context on
+// a replica connection are routed to the primary unless the replica is known to have replayed
+// the WAL up to the token, according to the replay LSN last sampled by the lag sampler, see
+// Config.ReplicaLagCheckInterval. The sampler is started by the first query carrying a token.
+// Carrying the token in the context allows generated code to be used without changes.
+func WithConsistencyToken(ctx context.Context, token ConsistencyToken) context.Context {
+	return context.WithValue(ctx, consistencyTokenKey{}, token)
+}
+
+// ConsistencyTokenFromContext returns the token carried by the context.
+func ConsistencyTokenFromContext(ctx context.Context) (token ConsistencyToken, ok bool) {
+	token, ok = ctx.Value(consistencyTokenKey{}).(ConsistencyToken)
+	return
+}
+
+// ConsistencyToken returns the current WAL LSN of the primary, read on any connection of the
+// pool. It is a low-level escape hatch for writes that cannot use Pool.TransactWithToken, e.g.
+// WConn.WExec: the token only covers writes that have returned before it is called.
+func (p *Pool) ConsistencyToken(ctx context.Context) (ConsistencyToken, error) {
+	return currentConsistencyToken(ctx, p.pool)
+}
+
+// rowQuerier is a pool or a connection, e.g. *pgxpool.Pool or *pgxpool.Conn.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// currentConsistencyToken returns the current WAL LSN of the primary, read on @p q.
+func currentConsistencyToken(ctx context.Context, q rowQuerier) (ConsistencyToken, error) {
+	var lsn string
+	if err := q.QueryRow(ctx, currentWalLSNQuery).Scan(&lsn); err != nil {
+		return 0, err
+	}
+	return ParseConsistencyToken(lsn)
+}
+
+// caughtUp returns true if the replica is known to have replayed the WAL up to the token.
+func (r *replica) caughtUp(token ConsistencyToken) bool {
+	return ConsistencyToken(r.replayLSN.Load()) >= token
+}
+
+// setReplayLSN caches the replay LSN of the replica. A nil @p lsn means the instance is not
+// a streaming replica, so tokens of the primary can never be satisfied.
+func (r *replica) setReplayLSN(lsn *string) error {
+	if lsn == nil {
+		r.replayLSN.Store(0)
+		return nil
+	}
+	token, err := ParseConsistencyToken(*lsn)
+	if err != nil {
+		return err
+	}
+	r.replayLSN.Store(uint64(token))
+	return nil
+}
@@ -0,0 +1,165 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logSQLMaxLength is the maximum length of the SQL text of slow queries in logs.
+const logSQLMaxLength = 2048
+
+// queryLogger logs queries as structured events, see Config.LogQueries.
+type queryLogger struct {
+	// all logs every query, otherwise only slow queries are logged.
+	all           bool
+	level         zerolog.Level
+	errorLevel    zerolog.Level
+	slowThreshold time.Duration
+	// handler, if set, receives the logs instead of the global zerolog logger.
+	handler slog.Handler
+}
+
+// newQueryLogger returns the query logger of @p config, or nil if queries are not logged.
+func newQueryLogger(config *Config) *queryLogger {
+	if !config.LogQueries && config.SlowQueryThreshold <= 0 {
+		return nil
+	}
+	errorLevel := config.QueryErrorLogLevel
+	// the zero value, e.g. of a Config built in code, must not log failed queries at DEBUG.
+	if errorLevel == zerolog.DebugLevel {
+		errorLevel = zerolog.ErrorLevel
+	}
+	return &queryLogger{
+		all:           config.LogQueries,
+		level:         config.QueryLogLevel,
+		errorLevel:    errorLevel,
+		slowThreshold: config.SlowQueryThreshold,
+		handler:       config.QueryLogHandler,
+	}
+}
+
+// Log logs the query named @p name that started at @p startedAt, with the number of rows it
+// returned, affected or copied, unless @p rows is negative, and its error, if any. ErrNoRows is
+// not an error. Slow queries are logged at WARN, or above, with their SQL text @p unprepared.
+func (l *queryLogger) Log(
+	ctx context.Context, name string, replicaName *ReplicaName, unprepared string,
+	startedAt time.Time, rows int64, err error,
+) {
+	duration := time.Since(startedAt)
+	slow := l.slowThreshold > 0 && duration >= l.slowThreshold
+	if !slow && !l.all {
+		return
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+	}
+	level := zerolog.Disabled
+	if l.all {
+		level = l.level
+		if err != nil {
+			level = l.errorLevel
+		}
+	}
+	if slow && (level < zerolog.WarnLevel || level >= zerolog.NoLevel) {
+		level = zerolog.WarnLevel
+	}
+	if level >= zerolog.NoLevel {
+		return
+	}
+	msg := "query"
+	if slow {
+		msg = "slow query"
+	}
+	traceID := ""
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		traceID = spanContext.TraceID().String()
+	}
+	if l.handler != nil {
+		l.logSlog(ctx, level, msg, name, replicaName, unprepared, duration, rows, err, slow, traceID)
+		return
+	}
+	event := log.WithLevel(level).
+		Str("query", name).
+		Str("replica", toLabel(replicaName)).
+		Dur("duration", duration)
+	if rows >= 0 {
+		event = event.Int64("rows", rows)
+	}
+	if err != nil {
+		event = event.Err(err)
+		if code := sqlState(err); code != "" {
+			event = event.Str("sqlstate", code)
+		}
+	}
+	if traceID != "" {
+		event = event.Str("trace_id", traceID)
+	}
+	if slow {
+		event = event.Str("sql", normalizeSQL(unprepared, logSQLMaxLength))
+	}
+	event.Msg(msg)
+}
+
+func (l *queryLogger) logSlog(
+	ctx context.Context, level zerolog.Level, msg, name string, replicaName *ReplicaName,
+	unprepared string, duration time.Duration, rows int64, err error, slow bool, traceID string,
+) {
+	slogLevel := toSlogLevel(level)
+	if !l.handler.Enabled(ctx, slogLevel) {
+		return
+	}
+	record := slog.NewRecord(time.Now(), slogLevel, msg, 0)
+	record.AddAttrs(
+		slog.String("query", name),
+		slog.String("replica", toLabel(replicaName)),
+		slog.Duration("duration", duration))
+	if rows >= 0 {
+		record.AddAttrs(slog.Int64("rows", rows))
+	}
+	if err != nil {
+		record.AddAttrs(slog.String("error", err.Error()))
+		if code := sqlState(err); code != "" {
+			record.AddAttrs(slog.String("sqlstate", code))
+		}
+	}
+	if traceID != "" {
+		record.AddAttrs(slog.String("trace_id", traceID))
+	}
+	if slow {
+		record.AddAttrs(slog.String("sql", normalizeSQL(unprepared, logSQLMaxLength)))
+	}
+	if err := l.handler.Handle(ctx, record); err != nil {
+		log.Warn().Err(err).Msg("failed to log query")
+	}
+}
+
+// copyFromSQL returns the statement sent by pgx for CopyFrom, to be logged as the SQL text.
+func copyFromSQL(tableName pgx.Identifier, columnNames []string) string {
+	quoted := make([]string, len(columnNames))
+	for i, name := range columnNames {
+		quoted[i] = pgx.Identifier{name}.Sanitize()
+	}
+	return fmt.Sprintf("COPY %s ( %s ) FROM STDIN BINARY", tableName.Sanitize(), strings.Join(quoted, ", "))
+}
+
+func toSlogLevel(level zerolog.Level) slog.Level {
+	switch {
+	case level <= zerolog.DebugLevel:
+		return slog.LevelDebug
+	case level == zerolog.InfoLevel:
+		return slog.LevelInfo
+	case level == zerolog.WarnLevel:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
@@ -0,0 +1,167 @@
+package wpgx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type QueryLoggerTestSuite struct {
+	suite.Suite
+	buf bytes.Buffer
+}
+
+func TestQueryLoggerTestSuite(t *testing.T) {
+	suite.Run(t, new(QueryLoggerTestSuite))
+}
+
+func (suite *QueryLoggerTestSuite) SetupTest() {
+	suite.buf.Reset()
+}
+
+// newLogger returns a logger of @p config that logs to the buffer of the suite, in JSON.
+func (suite *QueryLoggerTestSuite) newLogger(config *Config) *queryLogger {
+	config.QueryLogHandler = slog.NewJSONHandler(&suite.buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	return newQueryLogger(config)
+}
+
+// entries returns the logged entries and resets the buffer.
+func (suite *QueryLoggerTestSuite) entries() []map[string]any {
+	defer suite.buf.Reset()
+	var entries []map[string]any
+	decoder := json.NewDecoder(&suite.buf)
+	for decoder.More() {
+		entry := make(map[string]any)
+		suite.Require().NoError(decoder.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (suite *QueryLoggerTestSuite) TestDisabled() {
+	suite.Nil(newQueryLogger(&Config{}))
+	suite.NotNil(newQueryLogger(&Config{SlowQueryThreshold: time.Second}))
+}
+
+func (suite *QueryLoggerTestSuite) TestLevels() {
+	logger := suite.newLogger(&Config{
+		LogQueries:         true,
+		QueryLogLevel:      zerolog.InfoLevel,
+		QueryErrorLogLevel: zerolog.ErrorLevel,
+	})
+	r1 := ReplicaName("r1")
+	traceID := trace.TraceID{1}
+	ctx := trace.ContextWithSpanContext(context.Background(),
+		trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: trace.SpanID{1}}))
+
+	logger.Log(ctx, "GetOrder", &r1, "SELECT 1", time.Now(), 1, nil)
+	logger.Log(context.Background(), "GetMissing", nil, "SELECT 1", time.Now(), -1, pgx.ErrNoRows)
+	logger.Log(context.Background(), "Insert", nil, "INSERT", time.Now(), 0, &pgconn.PgError{Code: "23505"})
+	entries := suite.entries()
+	suite.Require().Len(entries, 3)
+
+	suite.Equal("INFO", entries[0]["level"])
+	suite.Equal("query", entries[0]["msg"])
+	suite.Equal("GetOrder", entries[0]["query"])
+	suite.Equal("r1", entries[0]["replica"])
+	suite.Equal(float64(1), entries[0]["rows"])
+	suite.Equal(traceID.String(), entries[0]["trace_id"])
+	suite.NotContains(entries[0], "sql")
+
+	suite.Equal("INFO", entries[1]["level"], "ErrNoRows is not an error")
+	suite.Equal("primary", entries[1]["replica"])
+	suite.NotContains(entries[1], "rows")
+	suite.NotContains(entries[1], "trace_id")
+
+	suite.Equal("ERROR", entries[2]["level"])
+	suite.Equal("23505", entries[2]["sqlstate"])
+	suite.Contains(entries[2], "error")
+}
+
+func (suite *QueryLoggerTestSuite) TestErrorLevelOfConfigInCode() {
+	config := unreachableConfig()
+	config.AppName = "logger_test"
+	config.LogQueries = true
+	suite.newLogger(config)
+	pool, err := NewPool(context.Background(), config)
+	suite.Require().NoError(err)
+	defer pool.Close()
+
+	// no server is listening on unreachablePort, so the query fails.
+	_, err = pool.WConn().WExec(context.Background(), "exec", "SELECT 1")
+	suite.Error(err)
+	entries := suite.entries()
+	suite.Require().Len(entries, 1)
+	suite.Equal("ERROR", entries[0]["level"])
+}
+
+func (suite *QueryLoggerTestSuite) TestSlowQuery() {
+	logger := suite.newLogger(&Config{SlowQueryThreshold: time.Second})
+	logger.Log(context.Background(), "Fast", nil, "SELECT 1", time.Now(), 1, nil)
+	logger.Log(context.Background(), "Slow", nil, "SELECT\n\t1", time.Now().Add(-2*time.Second), 1, nil)
+	entries := suite.entries()
+	suite.Require().Len(entries, 1, "only slow queries are logged without LogQueries")
+	suite.Equal("WARN", entries[0]["level"])
+	suite.Equal("slow query", entries[0]["msg"])
+	suite.Equal("Slow", entries[0]["query"])
+	suite.Equal("SELECT 1", entries[0]["sql"])
+
+	logger = suite.newLogger(&Config{
+		LogQueries:         true,
+		QueryErrorLogLevel: zerolog.ErrorLevel,
+		SlowQueryThreshold: time.Second,
+	})
+	logger.Log(context.Background(), "Slow", nil, "SELECT 1", time.Now().Add(-2*time.Second), 0, errors.New("failed"))
+	entries = suite.entries()
+	suite.Require().Len(entries, 1)
+	suite.Equal("ERROR", entries[0]["level"], "slow queries are logged at WARN, or above")
+	suite.Equal("SELECT 1", entries[0]["sql"])
+}
+
+func (suite *QueryLoggerTestSuite) TestZerolog() {
+	original := log.Logger
+	defer func() { log.Logger = original }()
+	log.Logger = zerolog.New(&suite.buf)
+
+	logger := newQueryLogger(&Config{LogQueries: true, QueryLogLevel: zerolog.InfoLevel})
+	logger.Log(context.Background(), "GetOrder", nil, "SELECT 1", time.Now(), 3, nil)
+	entries := suite.entries()
+	suite.Require().Len(entries, 1)
+	suite.Equal("info", entries[0]["level"])
+	suite.Equal("query", entries[0]["message"])
+	suite.Equal("GetOrder", entries[0]["query"])
+	suite.Equal(float64(3), entries[0]["rows"])
+}
+
+func (suite *QueryLoggerTestSuite) TestPool() {
+	config := unreachableConfig()
+	config.AppName = "logger_test"
+	config.LogQueries = true
+	config.QueryErrorLogLevel = zerolog.WarnLevel
+	suite.newLogger(config)
+	pool, err := NewPool(context.Background(), config)
+	suite.Require().NoError(err)
+	defer pool.Close()
+
+	conn := pool.WConn()
+	// no server is listening on unreachablePort, so queries fail.
+	_, err = conn.WExec(context.Background(), "exec", "SELECT 1")
+	suite.Error(err)
+	suite.Error(conn.WQueryRow(context.Background(), "query_row", "SELECT 1").Scan())
+	entries := suite.entries()
+	suite.Require().Len(entries, 2)
+	suite.Equal("exec", entries[0]["query"])
+	suite.Equal("WARN", entries[0]["level"])
+	suite.Equal("query_row", entries[1]["query"])
+}
@@ -0,0 +1,178 @@
+package wpgx
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MetricsRecorder records the metrics of a Pool. The built-in recorders are selected by
+// Config.EnablePrometheus and Config.EnableOTelMetrics, other backends, e.g. StatsD, can be
+// plugged in by Config.MetricsRecorder. Implementations must be safe for concurrent use, and
+// may embed NoopMetricsRecorder to implement only the observations they are interested in.
+type MetricsRecorder interface {
+	// MakeObserver returns a function, usually deferred, observing the query named @p name that
+	// started at @p startedAt and failed with *errPtr, if not nil.
+	MakeObserver(name string, replicaName *ReplicaName, startedAt time.Time, errPtr *error) func()
+	// ObserveFirstByte observes the time until the query returned, before rows are read.
+	ObserveFirstByte(name string, replicaName *ReplicaName, startedAt time.Time)
+	// ObserveAcquire observes the time that the query waited for a connection.
+	ObserveAcquire(name string, replicaName *ReplicaName, startedAt time.Time)
+	ObserveRowsReturned(name string, replicaName *ReplicaName, n int64)
+	ObserveRowsAffected(name string, replicaName *ReplicaName, n int64)
+	ObserveRowsCopied(name string, replicaName *ReplicaName, n int64)
+	// MakePostExecObserver is MakeObserver of PostExec functions, named after the query that
+	// registered them.
+	MakePostExecObserver(name string, replicaName *ReplicaName, startedAt time.Time, errPtr *error) func()
+	CountIntent(name string, replicaName *ReplicaName)
+	// CountError counts transactions, named after the transaction, that failed to begin, whose
+	// body returned an error or panicked, or that failed to commit. Errors of PostExec functions
+	// are not counted, see MakePostExecObserver.
+	CountError(name string, replicaName *ReplicaName)
+	CountTxRetry(replicaName *ReplicaName, code string)
+	// UpdateConnPoolGauge is called periodically with the stats of all pools.
+	UpdateConnPoolGauge(statsList []PoolStat)
+	UpdateReplicaLagGauge(replicaName *ReplicaName, lag time.Duration)
+	// DeleteReplicaLagGauge is called when the lag of the replica becomes unknown.
+	DeleteReplicaLagGauge(replicaName *ReplicaName)
+	UpdateReplicaHealthGauge(replicaName *ReplicaName, healthy bool)
+}
+
+// PoolStat is the stat of the primary pool, whose ReplicaName is nil, or of a replica pool.
+type PoolStat struct {
+	ReplicaName *ReplicaName
+	Stat        *pgxpool.Stat
+}
+
+var (
+	_ MetricsRecorder = (*metricSet)(nil)
+	_ MetricsRecorder = NoopMetricsRecorder{}
+	_ MetricsRecorder = (*InMemoryMetricsRecorder)(nil)
+)
+
+// NoopMetricsRecorder records nothing.
+type NoopMetricsRecorder struct{}
+
+func (NoopMetricsRecorder) MakeObserver(string, *ReplicaName, time.Time, *error) func() {
+	return func() {}
+}
+func (NoopMetricsRecorder) ObserveFirstByte(string, *ReplicaName, time.Time)  {}
+func (NoopMetricsRecorder) ObserveAcquire(string, *ReplicaName, time.Time)    {}
+func (NoopMetricsRecorder) ObserveRowsReturned(string, *ReplicaName, int64)   {}
+func (NoopMetricsRecorder) ObserveRowsAffected(string, *ReplicaName, int64)   {}
+func (NoopMetricsRecorder) ObserveRowsCopied(string, *ReplicaName, int64)     {}
+func (NoopMetricsRecorder) CountIntent(string, *ReplicaName)                  {}
+func (NoopMetricsRecorder) CountError(string, *ReplicaName)                   {}
+func (NoopMetricsRecorder) CountTxRetry(*ReplicaName, string)                 {}
+func (NoopMetricsRecorder) UpdateConnPoolGauge([]PoolStat)                    {}
+func (NoopMetricsRecorder) UpdateReplicaLagGauge(*ReplicaName, time.Duration) {}
+func (NoopMetricsRecorder) DeleteReplicaLagGauge(*ReplicaName)                {}
+func (NoopMetricsRecorder) UpdateReplicaHealthGauge(*ReplicaName, bool)       {}
+func (NoopMetricsRecorder) MakePostExecObserver(string, *ReplicaName, time.Time, *error) func() {
+	return func() {}
+}
+
+// Observation is a query observed by InMemoryMetricsRecorder.
+type Observation struct {
+	Name        string
+	ReplicaName *ReplicaName
+	Err         error
+	Duration    time.Duration
+}
+
+// InMemoryMetricsRecorder keeps the observed queries and intents in memory, so that tests
+// can assert which queries ran. Other observations are discarded.
+type InMemoryMetricsRecorder struct {
+	NoopMetricsRecorder
+
+	mutex        sync.Mutex
+	observations []Observation
+	intents      map[string]int
+}
+
+func NewInMemoryMetricsRecorder() *InMemoryMetricsRecorder {
+	return &InMemoryMetricsRecorder{intents: make(map[string]int)}
+}
+
+func (r *InMemoryMetricsRecorder) MakeObserver(
+	name string, replicaName *ReplicaName, startedAt time.Time, errPtr *error) func() {
+	return func() {
+		o := Observation{Name: name, ReplicaName: replicaName, Duration: time.Since(startedAt)}
+		if errPtr != nil {
+			o.Err = *errPtr
+		}
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.observations = append(r.observations, o)
+	}
+}
+
+func (r *InMemoryMetricsRecorder) CountIntent(name string, _ *ReplicaName) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.intents[name]++
+}
+
+// Observations returns the observed queries, in the order they were observed.
+func (r *InMemoryMetricsRecorder) Observations() []Observation {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Clone(r.observations)
+}
+
+// Names returns the sorted, distinct names of the observed queries.
+func (r *InMemoryMetricsRecorder) Names() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var names []string
+	for _, o := range r.observations {
+		names = append(names, o.Name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Count returns how many times the query named @p name was observed.
+func (r *InMemoryMetricsRecorder) Count(name string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := 0
+	for _, o := range r.observations {
+		if o.Name == name {
+			n++
+		}
+	}
+	return n
+}
+
+// Errors returns how many times the query named @p name failed, ErrNoRows excluded.
+func (r *InMemoryMetricsRecorder) Errors(name string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := 0
+	for _, o := range r.observations {
+		if o.Name == name && o.Err != nil && !errors.Is(o.Err, pgx.ErrNoRows) {
+			n++
+		}
+	}
+	return n
+}
+
+// Intents returns how many times the intent named @p name was counted.
+func (r *InMemoryMetricsRecorder) Intents(name string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.intents[name]
+}
+
+// Reset discards all observations.
+func (r *InMemoryMetricsRecorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.observations = nil
+	r.intents = make(map[string]int)
+}
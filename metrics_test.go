@@ -0,0 +1,48 @@
+package wpgx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsRecorderTestSuite struct {
+	suite.Suite
+}
+
+func TestMetricsRecorderTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsRecorderTestSuite))
+}
+
+func (suite *MetricsRecorderTestSuite) TestInMemoryRecorder() {
+	recorder := NewInMemoryMetricsRecorder()
+	config := unreachableConfig()
+	config.AppName = "recorder_test"
+	config.EnablePrometheus = true
+	config.MetricsRecorder = recorder
+	pool, err := NewPool(context.Background(), config)
+	suite.Require().NoError(err)
+	defer pool.Close()
+	suite.Same(recorder, pool.stats)
+
+	conn := pool.WConn()
+	// no server is listening on unreachablePort, so queries fail.
+	_, err = conn.WExec(context.Background(), "exec", "SELECT 1")
+	suite.Error(err)
+	suite.Error(conn.WQueryRow(context.Background(), "query_row", "SELECT 1").Scan())
+	suite.Error(conn.WQueryRow(context.Background(), "query_row", "SELECT 1").Scan())
+	conn.CountIntent("intent")
+
+	suite.Equal([]string{"exec", "query_row"}, recorder.Names())
+	suite.Equal(1, recorder.Count("exec"))
+	suite.Equal(2, recorder.Count("query_row"))
+	suite.Equal(2, recorder.Errors("query_row"))
+	suite.Equal(0, recorder.Count("unknown"))
+	suite.Equal(1, recorder.Intents("intent"))
+	suite.Len(recorder.Observations(), 3)
+
+	recorder.Reset()
+	suite.Empty(recorder.Names())
+	suite.Equal(0, recorder.Intents("intent"))
+}
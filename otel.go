@@ -2,11 +2,19 @@ package wpgx
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
-	semconv "go.opentelemetry.io/otel/semconv/v1.11.0"
+	semconvlegacy "go.opentelemetry.io/otel/semconv/v1.11.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -15,56 +23,214 @@ const (
 	// on each span.
 	tracerName = "github.com/stumble/wpgx"
 
-	// InstrumentationVersion is the version of the wpgx library. This will
-	// be used as an attribute on each span.
-	instrumentationVersion = "v0.0.1"
+	acquireTraceSpanName = "$ACQUIRE$"
 )
 
+// InstrumentationVersion is the version of the wpgx library, read from the build info of the
+// binary. This will be used as an attribute on each span.
+var instrumentationVersion = moduleVersion()
+
+// moduleVersion returns the version of the wpgx module that the binary is built with.
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == tracerName {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == tracerName {
+			if dep.Replace != nil && dep.Replace.Version != "" {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
 type tracer struct {
 	tracer trace.Tracer
 	attrs  []attribute.KeyValue
+	// instanceAttrs are the attributes of the instance that queries are sent to, keyed by
+	// the replica label, see toLabel.
+	instanceAttrs map[string][]attribute.KeyValue
+	// legacy uses the attributes of semconv v1.11.0, see Config.LegacyTraceSemconv.
+	legacy bool
+
+	// SQL text and arguments capture, see Config.TraceSQL.
+	traceSQL          bool
+	traceSQLMaxLength int
+	traceArgs         bool
+	argRedactor       ArgRedactor
+
+	// customization, see Config.TracerProvider.
+	spanName       SpanNameFunc
+	spanAttributes SpanAttributesFunc
+	rootSpans      bool
 }
 
 // NewTracer returns a new Tracer.
-func newTracer() *tracer {
-	return &tracer{
-		tracer: otel.GetTracerProvider().Tracer(
+func newTracer(config *Config) *tracer {
+	provider := config.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	t := &tracer{
+		tracer: provider.Tracer(
 			tracerName, trace.WithInstrumentationVersion(instrumentationVersion)),
 		attrs: []attribute.KeyValue{
-			semconv.DBSystemPostgreSQL,
+			semconv.DBSystemNamePostgreSQL,
+		},
+		instanceAttrs: map[string][]attribute.KeyValue{
+			toLabel(nil): instanceAttrs(config.DBName, config.Host, config.Port),
 		},
+		legacy:            config.LegacyTraceSemconv,
+		traceSQL:          config.TraceSQL,
+		traceSQLMaxLength: config.TraceSQLMaxLength,
+		traceArgs:         config.TraceArgs,
+		argRedactor:       config.TraceArgsRedactor,
+		spanName:          config.SpanNameFormatter,
+		spanAttributes:    config.SpanAttributes,
+		rootSpans:         config.TraceRootSpans,
+	}
+	if t.legacy {
+		t.attrs = []attribute.KeyValue{semconvlegacy.DBSystemPostgreSQL}
+	}
+	t.attrs = append(t.attrs, config.TraceAttributes...)
+	for _, replica := range config.ReadReplicas {
+		t.instanceAttrs[toLabel(&replica.Name)] = instanceAttrs(replica.DBName, replica.Host, replica.Port)
+	}
+	return t
+}
+
+func instanceAttrs(dbName, host string, port int) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.DBNamespace(dbName),
+		semconv.ServerAddress(host),
+		semconv.ServerPort(port),
 	}
 }
 
-func recordError(span trace.Span, err error) {
+func (t *tracer) recordError(span trace.Span, err error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		span.SetAttributes(attribute.Bool("wpgx.no_rows", true))
+		return
+	}
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
+		if t.legacy {
+			return
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			span.SetAttributes(semconv.DBResponseStatusCode(pgErr.Code), semconv.ErrorTypeKey.String(pgErr.Code))
+		} else {
+			span.SetAttributes(semconv.ErrorType(err))
+		}
 	}
 }
 
 // TraceStart is called at the beginning of Query, QueryRow, and Exec calls.
 // The returned context is used for the rest of the call and will be passed to TraceQueryEnd.
+// Without a recording parent span, no span is created unless Config.TraceRootSpans is set.
 func (t *tracer) TraceStart(ctx context.Context, queryName string, repliReplicaName *ReplicaName) context.Context {
-	if !trace.SpanFromContext(ctx).IsRecording() {
+	if !t.rootSpans && !trace.SpanFromContext(ctx).IsRecording() {
 		return ctx
 	}
 
 	opts := []trace.SpanStartOption{
 		trace.WithSpanKind(trace.SpanKindClient),
 		trace.WithAttributes(t.attrs...),
-		trace.WithAttributes(semconv.DBStatementKey.String(queryName)),
-		trace.WithAttributes(semconv.DBConnectionStringKey.String(toLabel(repliReplicaName))),
 	}
-	ctx, _ = t.tracer.Start(ctx, queryName, opts...)
+	if t.legacy {
+		opts = append(opts,
+			trace.WithAttributes(semconvlegacy.DBStatementKey.String(queryName)),
+			trace.WithAttributes(semconvlegacy.DBConnectionStringKey.String(toLabel(repliReplicaName))))
+	} else {
+		opts = append(opts,
+			trace.WithAttributes(semconv.DBOperationName(queryName), semconv.DBQuerySummary(queryName)),
+			trace.WithAttributes(replicaNameKey.String(toLabel(repliReplicaName))),
+			trace.WithAttributes(t.instanceAttrs[toLabel(repliReplicaName)]...))
+	}
+	if t.spanAttributes != nil {
+		opts = append(opts, trace.WithAttributes(t.spanAttributes(ctx, queryName)...))
+	}
+	spanName := queryName
+	if t.spanName != nil {
+		spanName = t.spanName(ctx, queryName)
+	}
+	ctx, _ = t.tracer.Start(ctx, spanName, opts...)
 	return ctx
 }
 
+// TraceSQL records the SQL text and the arguments of the query on the span started by TraceStart,
+// if enabled by Config.TraceSQL and Config.TraceArgs.
+func (t *tracer) TraceSQL(ctx context.Context, unprepared string, args []any) {
+	if !t.traceSQL && !t.traceArgs {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	if t.traceSQL {
+		span.SetAttributes(semconv.DBQueryText(normalizeSQL(unprepared, t.traceSQLMaxLength)))
+	}
+	if t.traceArgs {
+		attrs := make([]attribute.KeyValue, 0, len(args))
+		for i, arg := range args {
+			if t.argRedactor != nil {
+				arg = t.argRedactor(i, arg)
+			}
+			attrs = append(attrs, semconv.DBQueryParameter(strconv.Itoa(i), truncate(fmt.Sprint(arg), argMaxLength)))
+		}
+		span.SetAttributes(attrs...)
+	}
+}
+
 // TraceQueryEnd is called at the end of Query, QueryRow, and Exec calls.
 func (t *tracer) TraceEnd(ctx context.Context, errPtr *error) {
 	span := trace.SpanFromContext(ctx)
 	if errPtr != nil {
-		recordError(span, *errPtr)
+		t.recordError(span, *errPtr)
 	}
 	span.End()
 }
+
+// RecordError records the error on the span of the context.
+func (t *tracer) RecordError(ctx context.Context, err error) {
+	t.recordError(trace.SpanFromContext(ctx), err)
+}
+
+// TraceBatchQuery adds an event to the batch span when the result of a query in the batch is read.
+func (t *tracer) TraceBatchQuery(ctx context.Context, queryName string, err error) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	attrs := []attribute.KeyValue{semconv.DBQuerySummary(queryName)}
+	if t.legacy {
+		attrs = []attribute.KeyValue{semconvlegacy.DBStatementKey.String(queryName)}
+	}
+	if err != nil {
+		attrs = append(attrs, attribute.String("wpgx.batch.error", err.Error()))
+	}
+	span.AddEvent("batch_query", trace.WithAttributes(attrs...))
+}
+
+// TraceRetry adds an event to the transaction span when the transaction is about to be retried.
+func (t *tracer) TraceRetry(ctx context.Context, attempt int, err error, backoff time.Duration) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.AddEvent("retry", trace.WithAttributes(
+		attribute.Int("wpgx.tx.attempt", attempt),
+		attribute.String("wpgx.tx.sqlstate", sqlState(err)),
+		attribute.String("wpgx.tx.error", err.Error()),
+		attribute.Int64("wpgx.tx.backoff_ms", backoff.Milliseconds()),
+	))
+}
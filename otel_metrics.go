@@ -0,0 +1,127 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+const (
+	// meterName is the instrumentation scope name of the OpenTelemetry metrics.
+	meterName = tracerName
+
+	appNameKey     = attribute.Key("wpgx.app")
+	replicaNameKey = attribute.Key("wpgx.replica")
+)
+
+// operationDurationBucket is the bucket boundaries advised by the database client semantic
+// conventions for db.client.operation.duration, in seconds.
+var operationDurationBucket = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// otelMetrics records the observations of metricSet as OpenTelemetry metrics, following the
+// database client semantic conventions where they define an instrument.
+type otelMetrics struct {
+	appName      string
+	duration     metric.Float64Histogram
+	intent       metric.Int64Counter
+	errors       metric.Int64Counter
+	registration metric.Registration
+}
+
+// newOTelMetrics creates the instruments from @p meter. The connection pool instruments are
+// observed by calling @p poolStats on collection.
+func newOTelMetrics(meter metric.Meter, appName string, poolStats func() []PoolStat) (*otelMetrics, error) {
+	m := &otelMetrics{appName: appName}
+	var err error
+	m.duration, err = meter.Float64Histogram("db.client.operation.duration",
+		metric.WithDescription("Duration of database client operations."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(operationDurationBucket...))
+	if err != nil {
+		return nil, err
+	}
+	m.intent, err = meter.Int64Counter("wpgx.client.intent",
+		metric.WithDescription("Number of intent queries invoked, should be the sum of cached and hit_db."),
+		metric.WithUnit("{intent}"))
+	if err != nil {
+		return nil, err
+	}
+	m.errors, err = meter.Int64Counter("wpgx.client.errors",
+		metric.WithDescription("Number of failed transactions."),
+		metric.WithUnit("{error}"))
+	if err != nil {
+		return nil, err
+	}
+	connCount, err := meter.Int64ObservableUpDownCounter("db.client.connection.count",
+		metric.WithDescription("The number of connections that are currently in state described by the state attribute."),
+		metric.WithUnit("{connection}"))
+	if err != nil {
+		return nil, err
+	}
+	connMax, err := meter.Int64ObservableUpDownCounter("db.client.connection.max",
+		metric.WithDescription("The maximum number of open connections allowed."),
+		metric.WithUnit("{connection}"))
+	if err != nil {
+		return nil, err
+	}
+	m.registration, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, poolStats := range poolStats() {
+			stats := poolStats.Stat
+			attrs := m.attrs(poolStats.ReplicaName, semconv.DBClientConnectionPoolName(m.appName))
+			o.ObserveInt64(connMax, int64(stats.MaxConns()), metric.WithAttributes(attrs...))
+			o.ObserveInt64(connCount, int64(stats.IdleConns()),
+				metric.WithAttributes(append(attrs, semconv.DBClientConnectionStateIdle)...))
+			o.ObserveInt64(connCount, int64(stats.AcquiredConns()),
+				metric.WithAttributes(append(attrs, semconv.DBClientConnectionStateUsed)...))
+		}
+		return nil
+	}, connCount, connMax)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// attrs returns the attributes common to all instruments, followed by @p extra.
+func (m *otelMetrics) attrs(replicaName *ReplicaName, extra ...attribute.KeyValue) []attribute.KeyValue {
+	return append([]attribute.KeyValue{
+		semconv.DBSystemNamePostgreSQL,
+		appNameKey.String(m.appName),
+		replicaNameKey.String(toLabel(replicaName)),
+	}, extra...)
+}
+
+// ObserveOperation records the duration of the operation named @p name. ErrNoRows is not
+// recorded as an error.
+func (m *otelMetrics) ObserveOperation(name string, replicaName *ReplicaName, startedAt time.Time, err error) {
+	attrs := m.attrs(replicaName, semconv.DBOperationName(name))
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		if code := sqlState(err); code != "" {
+			attrs = append(attrs, semconv.ErrorTypeKey.String(code), semconv.DBResponseStatusCode(code))
+		} else {
+			attrs = append(attrs, semconv.ErrorTypeOther)
+		}
+	}
+	m.duration.Record(context.Background(), time.Since(startedAt).Seconds(), metric.WithAttributes(attrs...))
+}
+
+func (m *otelMetrics) CountIntent(name string, replicaName *ReplicaName) {
+	m.intent.Add(context.Background(), 1, metric.WithAttributes(m.attrs(replicaName, semconv.DBOperationName(name))...))
+}
+
+func (m *otelMetrics) CountError(name string, replicaName *ReplicaName) {
+	m.errors.Add(context.Background(), 1, metric.WithAttributes(m.attrs(replicaName, semconv.DBOperationName(name))...))
+}
+
+// Unregister stops observing the connection pools.
+func (m *otelMetrics) Unregister() {
+	if err := m.registration.Unregister(); err != nil {
+		log.Warn().Err(err).Msg("failed to unregister OpenTelemetry metrics callback")
+	}
+}
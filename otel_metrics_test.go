@@ -0,0 +1,115 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type OTelMetricsTestSuite struct {
+	suite.Suite
+	reader *sdkmetric.ManualReader
+	pool   *Pool
+}
+
+func TestOTelMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(OTelMetricsTestSuite))
+}
+
+func (suite *OTelMetricsTestSuite) SetupTest() {
+	suite.reader = sdkmetric.NewManualReader()
+	config := unreachableConfig()
+	config.AppName = "otel_test"
+	config.EnableOTelMetrics = true
+	config.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(suite.reader))
+	// connection pool metrics are only checked for the primary.
+	config.ReadReplicas = nil
+	pool, err := NewPool(context.Background(), config)
+	suite.Require().NoError(err)
+	suite.pool = pool
+}
+
+func (suite *OTelMetricsTestSuite) TearDownTest() {
+	suite.pool.Close()
+}
+
+func (suite *OTelMetricsTestSuite) collect() map[string]metricdata.Aggregation {
+	var rm metricdata.ResourceMetrics
+	suite.Require().NoError(suite.reader.Collect(context.Background(), &rm))
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	return metrics
+}
+
+func (suite *OTelMetricsTestSuite) TestObservations() {
+	conn := suite.pool.WConn()
+	// no server is listening on unreachablePort, so the query fails.
+	_, err := conn.WExec(context.Background(), "exec", "SELECT 1")
+	suite.Error(err)
+	conn.CountIntent("intent")
+
+	metrics := suite.collect()
+	duration, ok := metrics["db.client.operation.duration"].(metricdata.Histogram[float64])
+	suite.Require().True(ok)
+	suite.Require().Len(duration.DataPoints, 1)
+	point := duration.DataPoints[0]
+	suite.Equal(uint64(1), point.Count)
+	operation, _ := point.Attributes.Value("db.operation.name")
+	suite.Equal("exec", operation.AsString())
+	errorType, _ := point.Attributes.Value("error.type")
+	suite.Equal("_OTHER", errorType.AsString())
+
+	intent, ok := metrics["wpgx.client.intent"].(metricdata.Sum[int64])
+	suite.Require().True(ok)
+	suite.Require().Len(intent.DataPoints, 1)
+	suite.Equal(int64(1), intent.DataPoints[0].Value)
+
+	connMax, ok := metrics["db.client.connection.max"].(metricdata.Sum[int64])
+	suite.Require().True(ok)
+	suite.Require().Len(connMax.DataPoints, 1)
+	suite.Equal(int64(1), connMax.DataPoints[0].Value)
+	replica, _ := connMax.DataPoints[0].Attributes.Value(attribute.Key("wpgx.replica"))
+	suite.Equal("primary", replica.AsString())
+	_, ok = metrics["db.client.connection.count"].(metricdata.Sum[int64])
+	suite.True(ok)
+
+	// Prometheus is disabled.
+	suite.Nil(suite.pool.stats.(*metricSet).Request)
+}
+
+// failingMeterProvider provides meters that fail to create histograms.
+type failingMeterProvider struct {
+	noop.MeterProvider
+}
+
+func (failingMeterProvider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return failingMeter{}
+}
+
+type failingMeter struct {
+	noop.Meter
+}
+
+func (failingMeter) Float64Histogram(string, ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	return nil, errors.New("failed")
+}
+
+func (suite *OTelMetricsTestSuite) TestNewPoolFails() {
+	config := unreachableConfig()
+	config.AppName = "otel_test"
+	config.EnableOTelMetrics = true
+	config.MeterProvider = failingMeterProvider{}
+	_, err := NewPool(context.Background(), config)
+	suite.Error(err)
+}
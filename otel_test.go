@@ -0,0 +1,176 @@
+package wpgx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type TracerTestSuite struct {
+	suite.Suite
+	recorder *tracetest.SpanRecorder
+	provider *sdktrace.TracerProvider
+}
+
+func TestTracerTestSuite(t *testing.T) {
+	suite.Run(t, new(TracerTestSuite))
+}
+
+func (suite *TracerTestSuite) SetupTest() {
+	suite.recorder = tracetest.NewSpanRecorder()
+	suite.provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(suite.recorder))
+}
+
+// trace traces a query named @p name on @p replicaName, failed with @p err, and returns the
+// attributes of its span.
+func (suite *TracerTestSuite) trace(
+	config *Config, name string, replicaName *ReplicaName, err error) map[attribute.Key]attribute.Value {
+	config.TracerProvider = suite.provider
+	t := newTracer(config)
+	ctx, parent := suite.provider.Tracer("test").Start(context.Background(), "parent")
+	defer parent.End()
+	ctx = t.TraceStart(ctx, name, replicaName)
+	t.TraceEnd(ctx, &err)
+
+	spans := suite.recorder.Ended()
+	span := spans[len(spans)-1]
+	suite.Equal(name, span.Name())
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func (suite *TracerTestSuite) TestSemconv() {
+	config := &Config{
+		Host:   "primary.db",
+		Port:   5432,
+		DBName: "orders",
+		ReadReplicas: []ReadReplicaConfig{
+			{Name: "r1", Host: "replica.db", Port: 5433, DBName: "orders"},
+		},
+	}
+	attrs := suite.trace(config, "GetOrder", nil, nil)
+	suite.Equal("postgresql", attrs["db.system.name"].AsString())
+	suite.Equal("GetOrder", attrs["db.operation.name"].AsString())
+	suite.Equal("GetOrder", attrs["db.query.summary"].AsString())
+	suite.Equal("orders", attrs["db.namespace"].AsString())
+	suite.Equal("primary.db", attrs["server.address"].AsString())
+	suite.Equal(int64(5432), attrs["server.port"].AsInt64())
+	suite.Equal("primary", attrs["wpgx.replica"].AsString())
+	suite.NotContains(attrs, attribute.Key("db.statement"))
+
+	r1 := ReplicaName("r1")
+	attrs = suite.trace(config, "GetOrder", &r1, &pgconn.PgError{Code: SQLStateSerializationFailure})
+	suite.Equal("replica.db", attrs["server.address"].AsString())
+	suite.Equal(int64(5433), attrs["server.port"].AsInt64())
+	suite.Equal("r1", attrs["wpgx.replica"].AsString())
+	suite.Equal(SQLStateSerializationFailure, attrs["db.response.status_code"].AsString())
+	suite.Equal(SQLStateSerializationFailure, attrs["error.type"].AsString())
+}
+
+func (suite *TracerTestSuite) TestLegacySemconv() {
+	config := &Config{Host: "primary.db", Port: 5432, DBName: "orders", LegacyTraceSemconv: true}
+	attrs := suite.trace(config, "GetOrder", nil, &pgconn.PgError{Code: SQLStateSerializationFailure})
+	suite.Equal("postgresql", attrs["db.system"].AsString())
+	suite.Equal("GetOrder", attrs["db.statement"].AsString())
+	suite.Equal("primary", attrs["db.connection_string"].AsString())
+	suite.NotContains(attrs, attribute.Key("db.system.name"))
+	suite.NotContains(attrs, attribute.Key("db.response.status_code"))
+}
+
+func (suite *TracerTestSuite) TestModuleVersion() {
+	suite.NotEmpty(instrumentationVersion)
+}
+
+func (suite *TracerTestSuite) traceSQL(config *Config, unprepared string, args ...any) map[attribute.Key]attribute.Value {
+	config.TracerProvider = suite.provider
+	t := newTracer(config)
+	ctx, parent := suite.provider.Tracer("test").Start(context.Background(), "parent")
+	defer parent.End()
+	ctx = t.TraceStart(ctx, "query", nil)
+	t.TraceSQL(ctx, unprepared, args)
+	t.TraceEnd(ctx, nil)
+
+	spans := suite.recorder.Ended()
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[len(spans)-1].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func (suite *TracerTestSuite) TestTraceSQL() {
+	const query = "SELECT *\n  FROM users\n  WHERE email = $1 AND password = $2 AND age > $3"
+
+	// off by default.
+	attrs := suite.traceSQL(&Config{}, query, "a@b.c", "secret", 18)
+	suite.NotContains(attrs, attribute.Key("db.query.text"))
+	suite.NotContains(attrs, attribute.Key("db.query.parameter.0"))
+
+	attrs = suite.traceSQL(&Config{TraceSQL: true, TraceSQLMaxLength: 24}, query, "a@b.c", "secret", 18)
+	suite.Equal("SELECT * FROM users W...", attrs["db.query.text"].AsString())
+	suite.NotContains(attrs, attribute.Key("db.query.parameter.0"))
+
+	attrs = suite.traceSQL(&Config{TraceArgs: true, TraceArgsRedactor: RedactArgsAt(1)}, query, "a@b.c", "secret", 18)
+	suite.Equal("a@b.c", attrs["db.query.parameter.0"].AsString())
+	suite.Equal(RedactedArg, attrs["db.query.parameter.1"].AsString())
+	suite.Equal("18", attrs["db.query.parameter.2"].AsString())
+
+	attrs = suite.traceSQL(&Config{TraceArgs: true, TraceArgsRedactor: RedactArgsOfType("")}, query, "a@b.c", "secret", 18)
+	suite.Equal(RedactedArg, attrs["db.query.parameter.0"].AsString())
+	suite.Equal(RedactedArg, attrs["db.query.parameter.1"].AsString())
+	suite.Equal("18", attrs["db.query.parameter.2"].AsString())
+}
+
+func (suite *TracerTestSuite) TestTruncate() {
+	suite.Equal("abc", truncate("abc", 0))
+	suite.Equal("abc", truncate("abc", 3))
+	suite.Equal("a...", truncate("abcdef", 4))
+	// multi-byte characters are not broken.
+	suite.Equal("...", truncate("日本語", 5))
+	suite.Equal("日...", truncate("日本語", 6))
+}
+
+func (suite *TracerTestSuite) TestCustomization() {
+	type tenantKey struct{}
+	config := &Config{
+		TracerProvider:  suite.provider,
+		TraceAttributes: []attribute.KeyValue{attribute.String("service", "orders")},
+		SpanNameFormatter: func(_ context.Context, queryName string) string {
+			return "db." + queryName
+		},
+		SpanAttributes: func(ctx context.Context, _ string) []attribute.KeyValue {
+			return []attribute.KeyValue{attribute.String("tenant", ctx.Value(tenantKey{}).(string))}
+		},
+	}
+	t := newTracer(config)
+
+	// no span without a recording parent.
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	suite.Equal(ctx, t.TraceStart(ctx, "GetOrder", nil))
+
+	ctx, parent := suite.provider.Tracer("test").Start(ctx, "parent")
+	t.TraceEnd(t.TraceStart(ctx, "GetOrder", nil), nil)
+	parent.End()
+	span := suite.recorder.Ended()[0]
+	suite.Equal("db.GetOrder", span.Name())
+	suite.Equal(parent.SpanContext().SpanID(), span.Parent().SpanID())
+	suite.Contains(span.Attributes(), attribute.String("service", "orders"))
+	suite.Contains(span.Attributes(), attribute.String("tenant", "acme"))
+
+	// root spans for background jobs.
+	config.TraceRootSpans = true
+	t = newTracer(config)
+	ctx = context.WithValue(context.Background(), tenantKey{}, "acme")
+	t.TraceEnd(t.TraceStart(ctx, "CleanUp", nil), nil)
+	span = suite.recorder.Ended()[2]
+	suite.Equal("db.CleanUp", span.Name())
+	suite.False(span.Parent().IsValid())
+}
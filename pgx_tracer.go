@@ -0,0 +1,168 @@
+package wpgx
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	semconvlegacy "go.opentelemetry.io/otel/semconv/v1.11.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	connectTraceSpanName = "$CONNECT$"
+	prepareTraceSpanName = "$PREPARE$"
+)
+
+var (
+	_ pgx.QueryTracer       = (*pgxTracer)(nil)
+	_ pgx.BatchTracer       = (*pgxTracer)(nil)
+	_ pgx.CopyFromTracer    = (*pgxTracer)(nil)
+	_ pgx.PrepareTracer     = (*pgxTracer)(nil)
+	_ pgx.ConnectTracer     = (*pgxTracer)(nil)
+	_ pgxpool.AcquireTracer = (*pgxTracer)(nil)
+)
+
+// pgxSpanKey is the context key of the span started by pgxTracer, so that it is ended by the
+// matching end call, and never the span of the wpgx call when no span was started.
+type pgxSpanKey struct{}
+
+// pgxTracer traces the events of a pgx pool under the span of the wpgx call that caused them:
+// acquiring, connecting and preparing are traced as child spans, while queries, COPY and batches,
+// already covered by the span of the call, are added to it as events. Like TraceStart, nothing
+// is traced without a recording parent span.
+type pgxTracer struct {
+	t     *tracer
+	attrs []attribute.KeyValue
+}
+
+// newPgxTracer returns the pgx tracer of the pool of @p replicaName, nil for the primary,
+// or nil if tracing is disabled, i.e. @p t is nil.
+func newPgxTracer(t *tracer, replicaName *ReplicaName) *pgxTracer {
+	if t == nil {
+		return nil
+	}
+	pt := &pgxTracer{t: t}
+	if t.legacy {
+		pt.attrs = []attribute.KeyValue{semconvlegacy.DBConnectionStringKey.String(toLabel(replicaName))}
+	} else {
+		pt.attrs = append([]attribute.KeyValue{replicaNameKey.String(toLabel(replicaName))},
+			t.instanceAttrs[toLabel(replicaName)]...)
+	}
+	return pt
+}
+
+// startSpan starts a child span named @p name if the span of @p ctx is recording.
+func (pt *pgxTracer) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) context.Context {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return ctx
+	}
+	ctx, span := pt.t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(pt.t.attrs...),
+		trace.WithAttributes(pt.attrs...),
+		trace.WithAttributes(attrs...))
+	return context.WithValue(ctx, pgxSpanKey{}, span)
+}
+
+// endSpan ends the span started by startSpan, if any.
+func (pt *pgxTracer) endSpan(ctx context.Context, err error, attrs ...attribute.KeyValue) {
+	span, ok := ctx.Value(pgxSpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(attrs...)
+	pt.t.recordError(span, err)
+	span.End()
+}
+
+// addEvent adds an event named @p name to the span of @p ctx, if recording.
+func (pt *pgxTracer) addEvent(ctx context.Context, name string, err error, attrs ...attribute.KeyValue) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	if err != nil {
+		attrs = append(attrs, attribute.String("wpgx.pgx.error", err.Error()))
+	}
+	span.AddEvent(name, trace.WithAttributes(attrs...))
+}
+
+// sqlAttrs returns the SQL text attribute, if enabled by Config.TraceSQL.
+func (pt *pgxTracer) sqlAttrs(sql string) []attribute.KeyValue {
+	if !pt.t.traceSQL {
+		return nil
+	}
+	return []attribute.KeyValue{semconv.DBQueryText(normalizeSQL(sql, pt.t.traceSQLMaxLength))}
+}
+
+func commandTagAttr(tag pgconn.CommandTag) attribute.KeyValue {
+	return attribute.String("wpgx.pgx.command_tag", tag.String())
+}
+
+func (pt *pgxTracer) TraceAcquireStart(
+	ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	return pt.startSpan(ctx, acquireTraceSpanName)
+}
+
+func (pt *pgxTracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	pt.endSpan(ctx, data.Err)
+}
+
+func (pt *pgxTracer) TraceConnectStart(ctx context.Context, _ pgx.TraceConnectStartData) context.Context {
+	return pt.startSpan(ctx, connectTraceSpanName)
+}
+
+func (pt *pgxTracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	pt.endSpan(ctx, data.Err)
+}
+
+func (pt *pgxTracer) TracePrepareStart(
+	ctx context.Context, _ *pgx.Conn, data pgx.TracePrepareStartData) context.Context {
+	return pt.startSpan(ctx, prepareTraceSpanName,
+		append(pt.sqlAttrs(data.SQL), attribute.String("wpgx.pgx.statement_name", data.Name))...)
+}
+
+func (pt *pgxTracer) TracePrepareEnd(ctx context.Context, _ *pgx.Conn, data pgx.TracePrepareEndData) {
+	pt.endSpan(ctx, data.Err, attribute.Bool("wpgx.pgx.already_prepared", data.AlreadyPrepared))
+}
+
+func (pt *pgxTracer) TraceQueryStart(
+	ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+// TraceQueryEnd adds a pgx.query event, e.g. for each statement of a transaction, including
+// BEGIN and COMMIT, to the span of the transaction.
+func (pt *pgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	pt.addEvent(ctx, "pgx.query", data.Err, commandTagAttr(data.CommandTag))
+}
+
+func (pt *pgxTracer) TraceBatchStart(
+	ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	pt.addEvent(ctx, "pgx.batch_start", nil, attribute.Int("wpgx.pgx.batch_size", data.Batch.Len()))
+	return ctx
+}
+
+func (pt *pgxTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	pt.addEvent(ctx, "pgx.batch_query", data.Err, append(pt.sqlAttrs(data.SQL), commandTagAttr(data.CommandTag))...)
+}
+
+func (pt *pgxTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	pt.addEvent(ctx, "pgx.batch_end", data.Err)
+}
+
+func (pt *pgxTracer) TraceCopyFromStart(
+	ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	pt.addEvent(ctx, "pgx.copy_from_start", nil,
+		attribute.String("wpgx.pgx.table", data.TableName.Sanitize()),
+		attribute.StringSlice("wpgx.pgx.columns", data.ColumnNames))
+	return ctx
+}
+
+func (pt *pgxTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	pt.addEvent(ctx, "pgx.copy_from_end", data.Err, commandTagAttr(data.CommandTag))
+}
@@ -0,0 +1,110 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func (suite *TracerTestSuite) newPgxTracer(config *Config, replicaName *ReplicaName) *pgxTracer {
+	config.TracerProvider = suite.provider
+	return newPgxTracer(newTracer(config), replicaName)
+}
+
+func (suite *TracerTestSuite) TestPgxSpans() {
+	config := &Config{
+		TraceSQL: true,
+		ReadReplicas: []ReadReplicaConfig{
+			{Name: "r1", Host: "replica.db", Port: 5433, DBName: "orders"},
+		},
+	}
+	r1 := ReplicaName("r1")
+	pt := suite.newPgxTracer(config, &r1)
+	ctx, parent := suite.provider.Tracer("test").Start(context.Background(), "GetOrder")
+
+	acquireCtx := pt.TraceAcquireStart(ctx, nil, pgxpool.TraceAcquireStartData{})
+	connectCtx := pt.TraceConnectStart(acquireCtx, pgx.TraceConnectStartData{})
+	pt.TraceConnectEnd(connectCtx, pgx.TraceConnectEndData{Err: errors.New("refused")})
+	pt.TraceAcquireEnd(acquireCtx, nil, pgxpool.TraceAcquireEndData{})
+	prepareCtx := pt.TracePrepareStart(ctx, nil, pgx.TracePrepareStartData{Name: "stmt", SQL: "SELECT  1"})
+	pt.TracePrepareEnd(prepareCtx, nil, pgx.TracePrepareEndData{AlreadyPrepared: true})
+	parent.End()
+
+	spans := suite.recorder.Ended()
+	suite.Require().Len(spans, 4)
+	connect, acquire, prepare := spans[0], spans[1], spans[2]
+	suite.Equal(connectTraceSpanName, connect.Name())
+	suite.Equal(acquire.SpanContext().SpanID(), connect.Parent().SpanID())
+	suite.Equal(codes.Error, connect.Status().Code)
+	suite.Equal(acquireTraceSpanName, acquire.Name())
+	suite.Equal(parent.SpanContext().SpanID(), acquire.Parent().SpanID())
+	suite.Equal(codes.Unset, acquire.Status().Code)
+	suite.Equal(prepareTraceSpanName, prepare.Name())
+	suite.Equal(parent.SpanContext().SpanID(), prepare.Parent().SpanID())
+
+	attrs := make(map[string]string)
+	for _, kv := range acquire.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	suite.Equal("r1", attrs["wpgx.replica"])
+	suite.Equal("replica.db", attrs["server.address"])
+	attrs = make(map[string]string)
+	for _, kv := range prepare.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	suite.Equal("stmt", attrs["wpgx.pgx.statement_name"])
+	suite.Equal("SELECT 1", attrs["db.query.text"])
+	suite.Equal("true", attrs["wpgx.pgx.already_prepared"])
+}
+
+func (suite *TracerTestSuite) TestPgxEvents() {
+	pt := suite.newPgxTracer(&Config{}, nil)
+	ctx, parent := suite.provider.Tracer("test").Start(context.Background(), "BatchInsert")
+
+	batch := &pgx.Batch{}
+	batch.Queue("INSERT INTO t VALUES (1)")
+	ctx = pt.TraceBatchStart(ctx, nil, pgx.TraceBatchStartData{Batch: batch})
+	pt.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{
+		SQL: "INSERT INTO t VALUES (1)", CommandTag: pgconn.NewCommandTag("INSERT 0 1")})
+	pt.TraceBatchEnd(ctx, nil, pgx.TraceBatchEndData{})
+	ctx = pt.TraceCopyFromStart(ctx, nil, pgx.TraceCopyFromStartData{
+		TableName: pgx.Identifier{"t"}, ColumnNames: []string{"id"}})
+	pt.TraceCopyFromEnd(ctx, nil, pgx.TraceCopyFromEndData{Err: errors.New("broken pipe")})
+	ctx = pt.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "COMMIT"})
+	pt.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("COMMIT")})
+	parent.End()
+
+	spans := suite.recorder.Ended()
+	suite.Require().Len(spans, 1)
+	var names []string
+	for _, event := range spans[0].Events() {
+		names = append(names, event.Name)
+	}
+	suite.Equal([]string{
+		"pgx.batch_start", "pgx.batch_query", "pgx.batch_end",
+		"pgx.copy_from_start", "pgx.copy_from_end", "pgx.query",
+	}, names)
+	events := spans[0].Events()
+	suite.Equal("INSERT 0 1", events[1].Attributes[0].Value.AsString(), "SQL text is not traced by default")
+	suite.Equal("broken pipe", events[4].Attributes[1].Value.AsString())
+}
+
+func (suite *TracerTestSuite) TestPgxWithoutParent() {
+	pt := suite.newPgxTracer(&Config{TraceRootSpans: true}, nil)
+	ctx := pt.TraceAcquireStart(context.Background(), nil, pgxpool.TraceAcquireStartData{})
+	suite.False(trace.SpanFromContext(ctx).IsRecording())
+	pt.TraceAcquireEnd(ctx, nil, pgxpool.TraceAcquireEndData{})
+	pt.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	suite.Empty(suite.recorder.Ended())
+
+	// a pgx event without a span of its own must not end the span of the wpgx call.
+	ctx, parent := suite.provider.Tracer("test").Start(context.Background(), "parent")
+	pt.TraceConnectEnd(ctx, pgx.TraceConnectEndData{})
+	suite.True(parent.IsRecording())
+	parent.End()
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -19,10 +21,22 @@ const (
 
 // Pool is the wrapped pgx pool that registers Prometheus.
 type Pool struct {
-	pool         *pgxpool.Pool
-	replicaPools map[ReplicaName]*pgxpool.Pool // broken replica will use the primary pool
-	stats        *metricSet
-	tracer       *tracer
+	pool          *pgxpool.Pool
+	replicaPools  map[ReplicaName]*pgxpool.Pool // broken replica will use the primary pool
+	replicas      map[ReplicaName]*replica      // replicas that are not broken
+	replicaGroups map[ReplicaGroupName]*replicaGroup
+	stats         MetricsRecorder
+	tracer        *tracer
+	logger        *queryLogger
+	monitor       *monitorConn // connection to the primary of the replica lag sampler
+
+	maxReplicaLag    time.Duration
+	lagCheckInterval time.Duration
+	postExec         postExecConfig
+	recoverTxPanic   bool
+	// lagMonitorMutex guards starting the lag sampler against closing the pool.
+	lagMonitorMutex   sync.Mutex
+	lagMonitorStarted bool
 
 	// graceful shutdown utilities
 	ctx    context.Context
@@ -44,6 +58,8 @@ type pgxConfig struct {
 	BeforeAcquire   func(context.Context, *pgx.Conn) bool
 	IsProxy         bool
 	SSLMode         string
+	// Tracer, if not nil, traces the events of pgx, see pgxTracer.
+	Tracer *pgxTracer
 }
 
 func newRawPgxPool(ctx context.Context, config *pgxConfig) (*pgxpool.Pool, error) {
@@ -85,6 +101,9 @@ func newRawPgxPool(ctx context.Context, config *pgxConfig) (*pgxpool.Pool, error
 	if config.IsProxy {
 		pgConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
 	}
+	if config.Tracer != nil {
+		pgConfig.ConnConfig.Tracer = config.Tracer
+	}
 	return pgxpool.NewWithConfig(ctx, pgConfig)
 }
 
@@ -94,6 +113,10 @@ func NewPool(ctx context.Context, config *Config) (*Pool, error) {
 	if err := config.Valid(); err != nil {
 		return nil, err
 	}
+	var poolTracer *tracer
+	if config.EnableTracing {
+		poolTracer = newTracer(config)
+	}
 	primaryPool, err := newRawPgxPool(ctx, &pgxConfig{
 		Username:        config.Username,
 		Password:        config.Password,
@@ -108,13 +131,36 @@ func NewPool(ctx context.Context, config *Config) (*Pool, error) {
 		BeforeAcquire:   config.BeforeAcquire,
 		IsProxy:         config.IsProxy,
 		SSLMode:         config.SSLMode,
+		Tracer:          newPgxTracer(poolTracer, nil),
 	})
 	if err != nil {
 		return nil, err
 	}
 	pool := &Pool{
-		pool:         primaryPool,
-		replicaPools: make(map[ReplicaName]*pgxpool.Pool),
+		pool:             primaryPool,
+		replicaPools:     make(map[ReplicaName]*pgxpool.Pool),
+		replicas:         make(map[ReplicaName]*replica),
+		replicaGroups:    make(map[ReplicaGroupName]*replicaGroup),
+		maxReplicaLag:    config.MaxReplicaLag,
+		lagCheckInterval: config.ReplicaLagCheckInterval,
+		postExec: postExecConfig{
+			mode:        config.PostExecMode,
+			concurrency: config.PostExecConcurrency,
+			returnError: config.PostExecReturnError,
+		},
+		recoverTxPanic: config.RecoverTxPanic,
+		tracer:         poolTracer,
+		monitor:        newMonitorConn(primaryPool),
+		logger:         newQueryLogger(config),
+	}
+	// closePools closes the pgx pools opened so far, when NewPool fails.
+	closePools := func() {
+		for _, pp := range pool.replicaPools {
+			if pp != primaryPool {
+				pp.Close()
+			}
+		}
+		primaryPool.Close()
 	}
 	for _, replicaConfig := range config.ReadReplicas {
 		if replicaConfig.Broken {
@@ -136,22 +182,63 @@ func NewPool(ctx context.Context, config *Config) (*Pool, error) {
 			BeforeAcquire:   replicaConfig.BeforeAcquire,
 			IsProxy:         replicaConfig.IsProxy,
 			SSLMode:         replicaConfig.SSLMode,
+			Tracer:          newPgxTracer(poolTracer, &replicaConfig.Name),
 		})
 		if err != nil {
+			closePools()
 			return nil, err
 		}
 		pool.replicaPools[replicaConfig.Name] = replicaPool
+		pool.replicas[replicaConfig.Name] = newReplica(replicaConfig.Name, replicaPool)
 	}
-	if config.EnableTracing {
-		pool.tracer = newTracer()
+	for i := range config.ReplicaGroups {
+		group := newReplicaGroup(&config.ReplicaGroups[i], config.ReadReplicas)
+		pool.replicaGroups[group.name] = group
+	}
+	var otelStats *otelMetrics
+	if config.EnableOTelMetrics && config.MetricsRecorder == nil {
+		meterProvider := config.MeterProvider
+		if meterProvider == nil {
+			meterProvider = otel.GetMeterProvider()
+		}
+		otelStats, err = newOTelMetrics(
+			meterProvider.Meter(meterName, metric.WithInstrumentationVersion(instrumentationVersion)),
+			config.AppName, pool.connPoolStats)
+		if err != nil {
+			closePools()
+			return nil, err
+		}
 	}
 	pool.ctx, pool.cancel = context.WithCancel(context.Background())
-	if config.EnablePrometheus {
-		pool.stats = newMetricSet(config.AppName)
-		pool.stats.Register()
+	if config.MetricsRecorder != nil {
+		pool.stats = config.MetricsRecorder
+	} else if config.EnablePrometheus {
+		stats := newMetricSet(config.AppName, metricOptions{
+			constLabels:                    config.PrometheusConstLabels,
+			latencyBuckets:                 config.LatencyBuckets,
+			legacyMilliseconds:             config.LegacyMillisecondLatency,
+			nativeHistogramBucketFactor:    config.NativeHistogramBucketFactor,
+			nativeHistogramMaxBucketNumber: config.NativeHistogramMaxBucketNumber,
+		})
+		stats.Register(config.PrometheusRegisterer)
+		stats.otel = otelStats
+		pool.stats = stats
+	} else if otelStats != nil {
+		// Prometheus collectors are left nil, so that only OpenTelemetry metrics are recorded.
+		pool.stats = &metricSet{AppName: config.AppName, latencyUnit: time.Second, otel: otelStats}
+	}
+	if pool.stats != nil {
 		pool.wg.Add(1)
 		go pool.updateMetrics(ctx)
 	}
+	if config.MaxReplicaLag > 0 {
+		pool.startLagMonitor()
+	}
+	if config.HealthCheckInterval > 0 && len(pool.replicas) > 0 {
+		pool.wg.Add(1)
+		go pool.checkReplicaHealth(
+			config.HealthCheckInterval, config.HealthCheckFailureThreshold, config.HealthCheckRecoveryThreshold)
+	}
 	return pool, nil
 }
 
@@ -166,21 +253,27 @@ func (p *Pool) updateMetrics(_ context.Context) {
 			return
 		}
 		if p.stats != nil {
-			var allStats []pgxPoolStat
-			allStats = append(allStats, pgxPoolStat{replicaName: nil, stats: p.pool.Stat()})
-			for replicaName, replicaPool := range p.replicaPools {
-				// broken replica, skip
-				if replicaPool == p.pool {
-					continue
-				}
-				name := replicaName
-				allStats = append(allStats, pgxPoolStat{replicaName: &name, stats: replicaPool.Stat()})
-			}
+			allStats := p.connPoolStats()
 			p.stats.UpdateConnPoolGauge(allStats)
 		}
 	}
 }
 
+// connPoolStats returns the stats of the primary and all replica pools.
+func (p *Pool) connPoolStats() []PoolStat {
+	var allStats []PoolStat
+	allStats = append(allStats, PoolStat{ReplicaName: nil, Stat: p.pool.Stat()})
+	for replicaName, replicaPool := range p.replicaPools {
+		// broken replica, skip
+		if replicaPool == p.pool {
+			continue
+		}
+		name := replicaName
+		allStats = append(allStats, PoolStat{ReplicaName: &name, Stat: replicaPool.Stat()})
+	}
+	return allStats
+}
+
 // Close closes all pools, spawned goroutines, and cancels the context.
 func (p *Pool) Close() {
 	for _, pp := range p.replicaPools {
@@ -199,12 +292,19 @@ func (p *Pool) Close() {
 		defer p.wg.Done()
 		p.pool.Close()
 	}()
+	p.lagMonitorMutex.Lock()
 	p.cancel()
+	p.lagMonitorMutex.Unlock()
 	p.wg.Wait()
+	p.monitor.Close()
+	for _, r := range p.replicas {
+		r.monitor.Close()
+	}
 
 	// unregister after all	go routines are closed.
-	if p.stats != nil {
-		p.stats.Unregister()
+	// recorders provided by Config.MetricsRecorder are left to their owner.
+	if stats, ok := p.stats.(*metricSet); ok {
+		stats.Unregister()
 	}
 }
 
@@ -236,56 +336,217 @@ func (p *Pool) PingPrimary(ctx context.Context) error {
 
 // WConn returns a wrapped connection for the primary instance.
 func (p *Pool) WConn() *WConn {
-	return &WConn{p: p.pool, stats: p.stats, tracer: p.tracer}
+	return &WConn{p: p.pool, stats: p.stats, tracer: p.tracer, logger: p.logger}
 }
 
 // WQuerier returns a wrapped querier based on the given replica name.
 // When the name is nil, it returns the primary connection.
+// When the replica is broken, either configured or detected by the health checker,
+// it returns the primary connection.
+// When Config.MaxReplicaLag is set, the primary connection is returned if the replica
+// lags behind the primary by more than that, see WQuerierMaxLag.
 func (p *Pool) WQuerier(name *ReplicaName) (WQuerier, error) {
+	return p.WQuerierMaxLag(name, p.maxReplicaLag)
+}
+
+// WQuerierMaxLag is WQuerier with a per-call maximum replication lag, overriding
+// Config.MaxReplicaLag. When the replica lags behind the primary by more than @p maxLag,
+// or its lag has not been measured successfully, it returns the primary connection.
+// A zero @p maxLag means no limit. The lag sampler is started by the first call with a
+// positive @p maxLag, so that replicas are only used once their lag has been sampled.
+func (p *Pool) WQuerierMaxLag(name *ReplicaName, maxLag time.Duration) (WQuerier, error) {
 	if name == nil {
 		return p.WConn(), nil
 	}
-	pp, ok := p.replicaPools[*name]
-	if !ok {
-		return nil, fmt.Errorf("%w, name: %s", ErrReplicaNotFound, *name)
+	r, err := p.availableReplica(*name, maxLag)
+	if err != nil {
+		return nil, err
 	}
-	// This replica is configured as broken, use the primary pool instead.
-	if pp == p.pool {
+	if r == nil {
 		return p.WConn(), nil
 	}
-	return &WConn{p: pp, stats: p.stats, tracer: p.tracer, replicaName: name}, nil
+	return p.replicaWConn(r), nil
+}
+
+// availableReplica returns the replica by name, or nil if the primary should be used instead
+// because the replica is broken or lags behind the primary by more than @p maxLag.
+func (p *Pool) availableReplica(name ReplicaName, maxLag time.Duration) (*replica, error) {
+	if _, ok := p.replicaPools[name]; !ok {
+		return nil, fmt.Errorf("%w, name: %s", ErrReplicaNotFound, name)
+	}
+	r, ok := p.replicas[name]
+	// This replica is broken, use the primary pool instead.
+	if !ok || r.Broken() {
+		return nil, nil
+	}
+	if maxLag > 0 {
+		p.startLagMonitor()
+	}
+	if !r.withinLag(maxLag) {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// replicaWConn returns a wrapped connection for the replica.
+func (p *Pool) replicaWConn(r *replica) *WConn {
+	return &WConn{
+		p:               r.pool,
+		stats:           p.stats,
+		tracer:          p.tracer,
+		logger:          p.logger,
+		replicaName:     &r.name,
+		replica:         r,
+		primary:         p.pool,
+		startLagMonitor: p.startLagMonitor,
+	}
+}
+
+// ReplicaLag returns the last sampled replication lag of the replica, ok is false
+// if the replica is not found, broken, or its lag is unknown.
+func (p *Pool) ReplicaLag(name ReplicaName) (lag time.Duration, ok bool) {
+	r, found := p.replicas[name]
+	if !found {
+		return 0, false
+	}
+	return r.Lag()
 }
 
 // Transact is a wrapper of pgx.Transaction
 // It acquires a connection from the Pool and starts a transaction with pgx.TxOptions determining the transaction mode.
 // The context will be used when executing the transaction control statements (BEGIN, ROLLBACK, and COMMIT),
 // and when if tracing is enabled, the context with transaction span will be passed down to @p fn.
+// When Config.PostExecReturnError is set and PostExec functions failed, both the response and
+// an error wrapping ErrPostExec are returned, the transaction has been committed in that case.
+// When @p fn panics, the transaction is rolled back, and the panic is propagated, or returned
+// as *TxPanicError if Config.RecoverTxPanic is set.
 func (p *Pool) Transact(ctx context.Context, txOptions pgx.TxOptions, fn TxFunc) (resp interface{}, err error) {
 	if p.tracer != nil {
 		ctx = p.tracer.TraceStart(ctx, transactionTraceSpanName, nil)
 		defer p.tracer.TraceEnd(ctx, &err)
 	}
-	pgxTx, err := p.pool.BeginTx(ctx, txOptions)
+	return p.transact(ctx, p.pool, nil, txOptions, fn)
+}
+
+// TransactWithToken is Transact that also returns the ConsistencyToken of the transaction: the
+// current WAL LSN of the primary, read on the connection of the transaction right after it is
+// committed, so that reads carrying the token, see WithConsistencyToken, see its writes.
+// When the token cannot be read, both the response and an error wrapping ErrConsistencyToken
+// are returned, the transaction has been committed in that case.
+func (p *Pool) TransactWithToken(
+	ctx context.Context, txOptions pgx.TxOptions, fn TxFunc) (resp interface{}, token ConsistencyToken, err error) {
+	if p.tracer != nil {
+		ctx = p.tracer.TraceStart(ctx, transactionTraceSpanName, nil)
+		defer p.tracer.TraceEnd(ctx, &err)
+	}
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		if p.stats != nil {
+			p.stats.CountError(transactionTraceSpanName, nil)
+		}
+		return nil, 0, err
+	}
+	defer conn.Release()
+	resp, err = p.transact(ctx, conn, nil, txOptions, fn)
+	if err != nil && !errors.Is(err, ErrPostExec) {
+		return nil, 0, err
+	}
+	token, tokenErr := currentConsistencyToken(ctx, conn)
+	if tokenErr != nil {
+		return resp, 0, errors.Join(err, fmt.Errorf("%w: %w", ErrConsistencyToken, tokenErr))
+	}
+	return resp, token, err
+}
+
+// TransactWithRetry is Transact that re-runs the whole transaction, including @p fn, when it
+// fails with a serialization failure or a deadlock, see IsRetryableTxError. Retries are delayed
+// by a jittered exponential backoff according to @p policy. PostExec functions registered by
+// failed attempts are discarded. @p fn must be safe to run multiple times.
+func (p *Pool) TransactWithRetry(
+	ctx context.Context, txOptions pgx.TxOptions, policy RetryPolicy, fn TxFunc) (resp interface{}, err error) {
+	if p.tracer != nil {
+		ctx = p.tracer.TraceStart(ctx, transactionTraceSpanName, nil)
+		defer p.tracer.TraceEnd(ctx, &err)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err = p.transact(ctx, p.pool, nil, txOptions, fn)
+		// PostExec errors are returned after commit, the transaction must not be retried.
+		if err == nil || attempt >= policy.MaxAttempts || !IsRetryableTxError(err) || errors.Is(err, ErrPostExec) {
+			return resp, err
+		}
+		backoff := policy.backoff(attempt)
+		if p.stats != nil {
+			p.stats.CountTxRetry(nil, sqlState(err))
+		}
+		if p.tracer != nil {
+			p.tracer.TraceRetry(ctx, attempt, err, backoff)
+		}
+		if sleepErr := sleep(ctx, backoff); sleepErr != nil {
+			return nil, fmt.Errorf("retry aborted: %w, last error: %w", sleepErr, err)
+		}
+	}
+}
+
+// TransactReplica is Transact on a replica, in read-only access mode. It is useful for
+// running multiple queries on the same snapshot, e.g. with pgx.RepeatableRead isolation level.
+// When @p name is nil, or the replica is broken or lags behind the primary by more than
+// Config.MaxReplicaLag, the transaction runs on the primary.
+func (p *Pool) TransactReplica(
+	ctx context.Context, name *ReplicaName, txOptions pgx.TxOptions, fn ReadOnlyTxFunc) (resp interface{}, err error) {
+	pp := p.pool
+	var replicaName *ReplicaName
+	if name != nil {
+		r, err := p.availableReplica(*name, p.maxReplicaLag)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			pp, replicaName = r.pool, &r.name
+		}
+	}
+	if p.tracer != nil {
+		ctx = p.tracer.TraceStart(ctx, transactionTraceSpanName, replicaName)
+		defer p.tracer.TraceEnd(ctx, &err)
+	}
+	txOptions.AccessMode = pgx.ReadOnly
+	return p.transact(ctx, pp, replicaName, txOptions, func(ctx context.Context, tx *WTx) (any, error) {
+		return fn(ctx, &WRTx{tx: tx})
+	})
+}
+
+// txBeginner begins transactions, i.e. *pgxpool.Pool or *pgxpool.Conn.
+type txBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+// transact runs @p fn in a transaction on @p b, without tracing the transaction.
+func (p *Pool) transact(
+	ctx context.Context, b txBeginner, replicaName *ReplicaName, txOptions pgx.TxOptions, fn TxFunc,
+) (resp interface{}, err error) {
+	pgxTx, err := b.BeginTx(ctx, txOptions)
 	if err != nil {
+		if p.stats != nil {
+			p.stats.CountError(transactionTraceSpanName, replicaName)
+		}
 		return nil, err
 	}
 	tx := &WTx{
-		tx:     pgxTx,
-		stats:  p.stats,
-		tracer: p.tracer,
-	}
-	defer func() {
-		rollbackErr := tx.Rollback(ctx)
-		if rollbackErr != nil && !errors.Is(rollbackErr, pgx.ErrTxClosed) {
-			err = fmt.Errorf("rollback error: %w, original error: %w", rollbackErr, err)
-		}
-	}()
+		tx:           pgxTx,
+		stats:        p.stats,
+		tracer:       p.tracer,
+		logger:       p.logger,
+		replicaName:  replicaName,
+		postExec:     p.postExec,
+		recoverPanic: p.recoverTxPanic,
+	}
+	defer tx.finish(ctx, transactionTraceSpanName, &err)
 	resp, err = fn(ctx, tx)
+	tx.bodyReturned = true
 	if err != nil {
 		return nil, err
 	}
 	err = tx.Commit(ctx)
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrPostExec) {
 		return nil, err
 	}
 	return resp, err
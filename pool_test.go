@@ -0,0 +1,357 @@
+package wpgx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+// unreachablePort is a port that no PostgreSQL instance listens on.
+const unreachablePort = 1
+
+// unreachableConfig returns the config of a pool, with replicas r1 and r2, whose instances are
+// all on unreachablePort, so that pools can be created without a database.
+func unreachableConfig() *Config {
+	replica := func(name ReplicaName) ReadReplicaConfig {
+		return ReadReplicaConfig{
+			Name: name, Username: "postgres", Host: "localhost", Port: unreachablePort,
+			DBName: "wpgx_test_db", MaxConns: 1, SSLMode: "disable",
+		}
+	}
+	r2 := replica("r2")
+	r2.Weight = 3
+	return &Config{
+		Username:     "postgres",
+		Host:         "localhost",
+		Port:         unreachablePort,
+		DBName:       "wpgx_test_db",
+		MaxConns:     1,
+		SSLMode:      "disable",
+		AppName:      "pool_test",
+		ReadReplicas: []ReadReplicaConfig{replica("r1"), r2},
+	}
+}
+
+// PoolTestSuite tests the routing logic of Pool. Pools are created lazily by pgx,
+// so no PostgreSQL instance is required as long as no query is sent.
+type PoolTestSuite struct {
+	suite.Suite
+	pool *Pool
+}
+
+func TestPoolTestSuite(t *testing.T) {
+	suite.Run(t, new(PoolTestSuite))
+}
+
+func (suite *PoolTestSuite) SetupTest() {
+	config := unreachableConfig()
+	config.ReadReplicas = append(config.ReadReplicas, ReadReplicaConfig{Name: "broken", Broken: true})
+	config.ReplicaGroups = []ReplicaGroupConfig{
+		{Name: "rr", Members: []ReplicaName{"r1", "r2", "broken"}},
+		{Name: "weighted", Members: []ReplicaName{"r1", "r2"}, Strategy: WeightedRandom},
+		{Name: "least", Members: []ReplicaName{"r1", "r2"}, Strategy: LeastAcquiredConns},
+	}
+	pool, err := NewPool(context.Background(), config)
+	suite.Require().NoError(err)
+	suite.pool = pool
+}
+
+func (suite *PoolTestSuite) TearDownTest() {
+	suite.pool.Close()
+}
+
+func (suite *PoolTestSuite) replicaOf(q WQuerier) *ReplicaName {
+	conn, ok := q.(*WConn)
+	suite.Require().True(ok)
+	return conn.replicaName
+}
+
+func (suite *PoolTestSuite) TestWQuerierMaxLag() {
+	r1 := ReplicaName("r1")
+	broken := ReplicaName("broken")
+	unknown := ReplicaName("unknown")
+
+	q, err := suite.pool.WQuerier(nil)
+	suite.Require().NoError(err)
+	suite.Nil(suite.replicaOf(q))
+
+	_, err = suite.pool.WQuerier(&unknown)
+	suite.ErrorIs(err, ErrReplicaNotFound)
+
+	q, err = suite.pool.WQuerier(&broken)
+	suite.Require().NoError(err)
+	suite.Nil(suite.replicaOf(q))
+
+	// lag is unknown.
+	q, err = suite.pool.WQuerierMaxLag(&r1, 0)
+	suite.Require().NoError(err)
+	suite.Equal(&r1, suite.replicaOf(q))
+	q, err = suite.pool.WQuerierMaxLag(&r1, time.Second)
+	suite.Require().NoError(err)
+	suite.Nil(suite.replicaOf(q))
+
+	suite.pool.replicas[r1].lag.Store(int64(500 * time.Millisecond))
+	lag, ok := suite.pool.ReplicaLag(r1)
+	suite.True(ok)
+	suite.Equal(500*time.Millisecond, lag)
+	q, err = suite.pool.WQuerierMaxLag(&r1, time.Second)
+	suite.Require().NoError(err)
+	suite.Equal(&r1, suite.replicaOf(q))
+	q, err = suite.pool.WQuerierMaxLag(&r1, 100*time.Millisecond)
+	suite.Require().NoError(err)
+	suite.Nil(suite.replicaOf(q))
+
+	// pool-level default.
+	suite.pool.maxReplicaLag = 100 * time.Millisecond
+	q, err = suite.pool.WQuerier(&r1)
+	suite.Require().NoError(err)
+	suite.Nil(suite.replicaOf(q))
+}
+
+func (suite *PoolTestSuite) TestLagMonitorStartedOnUse() {
+	r1 := ReplicaName("r1")
+	suite.pool.lagCheckInterval = time.Hour
+	_, err := suite.pool.WQuerier(&r1)
+	suite.Require().NoError(err)
+	suite.False(suite.pool.lagMonitorStarted, "the lag is not used")
+	_, err = suite.pool.WQuerierMaxLag(&r1, time.Second)
+	suite.Require().NoError(err)
+	suite.True(suite.pool.lagMonitorStarted)
+}
+
+func (suite *PoolTestSuite) TestLagOf() {
+	seconds := 2.5
+	lag, err := lagOf(false, nil, 0, 100)
+	suite.Require().NoError(err)
+	suite.Zero(lag, "instances not in recovery have no lag")
+	lag, err = lagOf(true, &seconds, 100, 100)
+	suite.Require().NoError(err)
+	suite.Zero(lag, "standbys that replayed the WAL of the primary have no lag")
+	lag, err = lagOf(true, &seconds, 99, 100)
+	suite.Require().NoError(err)
+	suite.Equal(2500*time.Millisecond, lag)
+	_, err = lagOf(true, nil, 99, 100)
+	suite.ErrorIs(err, errLagUnknown)
+}
+
+func (suite *PoolTestSuite) TestSampleLagWithoutPrimary() {
+	r1 := ReplicaName("r1")
+	suite.pool.replicas[r1].lag.Store(0)
+	// no server is listening on unreachablePort, so the primary cannot be sampled.
+	suite.pool.sampleReplicasLag(time.Second)
+	_, ok := suite.pool.ReplicaLag(r1)
+	suite.False(ok)
+}
+
+func (suite *PoolTestSuite) TestHealthCheckMarksBroken() {
+	r1 := ReplicaName("r1")
+	r := suite.pool.replicas[r1]
+
+	// no server is listening on unreachablePort, so every ping fails.
+	for i := 0; i < 2; i++ {
+		suite.pool.pingReplica(r, time.Second, 3, 1)
+		suite.False(r.Broken())
+	}
+	q, err := suite.pool.WQuerier(&r1)
+	suite.Require().NoError(err)
+	suite.Equal(&r1, suite.replicaOf(q))
+
+	suite.pool.pingReplica(r, time.Second, 3, 1)
+	suite.True(r.Broken())
+	q, err = suite.pool.WQuerier(&r1)
+	suite.Require().NoError(err)
+	suite.Nil(suite.replicaOf(q))
+
+	r.broken.Store(false)
+	q, err = suite.pool.WQuerier(&r1)
+	suite.Require().NoError(err)
+	suite.Equal(&r1, suite.replicaOf(q))
+}
+
+func (suite *PoolTestSuite) TestWQuerierGroup() {
+	ctx := context.Background()
+	r1 := ReplicaName("r1")
+	r2 := ReplicaName("r2")
+
+	_, err := suite.pool.WQuerierGroup(ctx, "unknown")
+	suite.ErrorIs(err, ErrReplicaGroupNotFound)
+
+	// round robin skips the configured broken member.
+	picked := make(map[ReplicaName]int)
+	for i := 0; i < 10; i++ {
+		q, err := suite.pool.WQuerierGroup(ctx, "rr")
+		suite.Require().NoError(err)
+		suite.Require().NotNil(suite.replicaOf(q))
+		picked[*suite.replicaOf(q)]++
+	}
+	suite.Equal(map[ReplicaName]int{r1: 5, r2: 5}, picked)
+
+	picked = make(map[ReplicaName]int)
+	for i := 0; i < 1000; i++ {
+		q, err := suite.pool.WQuerierGroup(ctx, "weighted")
+		suite.Require().NoError(err)
+		picked[*suite.replicaOf(q)]++
+	}
+	suite.Greater(picked[r2], picked[r1])
+
+	q, err := suite.pool.WQuerierGroup(ctx, "least")
+	suite.Require().NoError(err)
+	suite.NotNil(suite.replicaOf(q))
+
+	// members marked as broken at runtime are skipped.
+	suite.pool.replicas[r1].broken.Store(true)
+	for i := 0; i < 3; i++ {
+		q, err := suite.pool.WQuerierGroup(ctx, "rr")
+		suite.Require().NoError(err)
+		suite.Equal(&r2, suite.replicaOf(q))
+	}
+	suite.pool.replicas[r2].broken.Store(true)
+	q, err = suite.pool.WQuerierGroup(ctx, "rr")
+	suite.Require().NoError(err)
+	suite.Nil(suite.replicaOf(q))
+}
+
+func (suite *PoolTestSuite) TestParseConsistencyToken() {
+	token, err := ParseConsistencyToken("16/B374D848")
+	suite.Require().NoError(err)
+	suite.Equal(ConsistencyToken(0x16_B374D848), token)
+	suite.Equal("16/B374D848", token.String())
+
+	_, err = ParseConsistencyToken("invalid")
+	suite.Error(err)
+
+	_, ok := ConsistencyTokenFromContext(context.Background())
+	suite.False(ok)
+	got, ok := ConsistencyTokenFromContext(WithConsistencyToken(context.Background(), token))
+	suite.True(ok)
+	suite.Equal(token, got)
+}
+
+func (suite *PoolTestSuite) TestConsistencyTokenRouting() {
+	r1 := ReplicaName("r1")
+	r2 := ReplicaName("r2")
+	suite.pool.lagCheckInterval = time.Hour
+	suite.pool.replicas[r1].replayLSN.Store(100)
+
+	q, err := suite.pool.WQuerier(&r1)
+	suite.Require().NoError(err)
+	conn := q.(*WConn)
+
+	_, replicaName := conn.route(context.Background())
+	suite.Equal(&r1, replicaName)
+	suite.False(suite.pool.lagMonitorStarted)
+	// the replay LSN is sampled once a query carries a token.
+	_, replicaName = conn.route(WithConsistencyToken(context.Background(), 100))
+	suite.Equal(&r1, replicaName)
+	suite.True(suite.pool.lagMonitorStarted)
+	pp, replicaName := conn.route(WithConsistencyToken(context.Background(), 101))
+	suite.Nil(replicaName)
+	suite.Equal(suite.pool.pool, pp)
+
+	// group members that have caught up are preferred.
+	ctx := WithConsistencyToken(context.Background(), 100)
+	for i := 0; i < 3; i++ {
+		q, err := suite.pool.WQuerierGroup(ctx, "rr")
+		suite.Require().NoError(err)
+		suite.Equal(&r1, suite.replicaOf(q))
+	}
+	picked := make(map[ReplicaName]int)
+	ctx = WithConsistencyToken(context.Background(), 101)
+	for i := 0; i < 4; i++ {
+		q, err := suite.pool.WQuerierGroup(ctx, "rr")
+		suite.Require().NoError(err)
+		picked[*suite.replicaOf(q)]++
+	}
+	suite.Equal(map[ReplicaName]int{r1: 2, r2: 2}, picked)
+}
+
+func (suite *PoolTestSuite) TestTransactWithTokenFails() {
+	stats := newMetricSet("pool_test", metricOptions{})
+	suite.pool.stats = stats
+
+	// no server is listening on unreachablePort, so no connection can be acquired.
+	resp, token, err := suite.pool.TransactWithToken(context.Background(), pgx.TxOptions{},
+		func(ctx context.Context, tx *WTx) (any, error) {
+			suite.Fail("should not run")
+			return nil, nil
+		})
+	suite.Error(err)
+	suite.Nil(resp)
+	suite.Zero(token)
+	suite.Equal(1.0, testutil.ToFloat64(stats.Error.WithLabelValues("pool_test", transactionTraceSpanName, "primary")))
+}
+
+func (suite *PoolTestSuite) TestTransactReplicaNotFound() {
+	unknown := ReplicaName("unknown")
+	_, err := suite.pool.TransactReplica(context.Background(), &unknown, pgx.TxOptions{},
+		func(ctx context.Context, tx *WRTx) (any, error) {
+			suite.Fail("should not run")
+			return nil, nil
+		})
+	suite.ErrorIs(err, ErrReplicaNotFound)
+}
+
+func (suite *PoolTestSuite) TestAcquireObserved() {
+	stats := newMetricSet("pool_test", metricOptions{})
+	suite.pool.stats = stats
+	conn := suite.pool.WConn()
+
+	// no server is listening on unreachablePort, so acquiring fails.
+	_, err := conn.WExec(context.Background(), "exec", "SELECT 1")
+	suite.Error(err)
+	count, _ := histogramOf(suite.T(), stats.AcquireLatency.WithLabelValues("pool_test", "exec", "primary"))
+	suite.Equal(uint64(1), count)
+	suite.Equal(1.0, testutil.ToFloat64(stats.Error.WithLabelValues("pool_test", "exec", "primary")))
+
+	err = conn.WQueryRow(context.Background(), "query_row", "SELECT 1").Scan()
+	suite.Error(err)
+	count, _ = histogramOf(suite.T(), stats.AcquireLatency.WithLabelValues("pool_test", "query_row", "primary"))
+	suite.Equal(uint64(1), count)
+
+	// the rows of a failed query are safe to read, as with pgx.
+	rows, err := conn.WQuery(context.Background(), "query", "SELECT 1")
+	suite.Error(err)
+	suite.Require().NotNil(rows)
+	suite.False(rows.Next())
+	suite.Error(rows.Err())
+	rows.Close()
+
+	batch := &WBatch{}
+	batch.Queue("batch_query", "SELECT 1")
+	results := conn.WSendBatch(context.Background(), "batch", batch)
+	_, err = results.Exec()
+	suite.Error(err)
+	suite.Error(results.Close())
+	suite.Equal(1.0, testutil.ToFloat64(stats.Error.WithLabelValues("pool_test", "batch_query", "primary")))
+
+	// failed acquires are not counted by pgxpool.
+	stats.UpdateConnPoolGauge([]PoolStat{{Stat: suite.pool.pool.Stat()}})
+	stats.UpdateConnPoolGauge([]PoolStat{{Stat: suite.pool.pool.Stat()}})
+	suite.Equal(6, testutil.CollectAndCount(stats.ConnPoolCounter))
+	suite.Equal(0.0, testutil.ToFloat64(stats.ConnPoolCounter.WithLabelValues("pool_test", "acquire", "primary")))
+}
+
+func (suite *PoolTestSuite) TestTransactErrorCounted() {
+	stats := newMetricSet("pool_test", metricOptions{})
+	suite.pool.stats = stats
+
+	// no server is listening on unreachablePort, so the transaction fails to begin.
+	_, err := suite.pool.Transact(context.Background(), pgx.TxOptions{},
+		func(ctx context.Context, tx *WTx) (any, error) {
+			suite.Fail("should not run")
+			return nil, nil
+		})
+	suite.Error(err)
+	suite.Equal(1.0, testutil.ToFloat64(stats.Error.WithLabelValues("pool_test", transactionTraceSpanName, "primary")))
+}
+
+func (suite *PoolTestSuite) TestCounterDelta() {
+	suite.Equal(int64(3), counterDelta(2, 5))
+	suite.Equal(int64(0), counterDelta(5, 5))
+	// the counter was reset.
+	suite.Equal(int64(1), counterDelta(5, 1))
+}
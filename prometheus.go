@@ -1,89 +1,353 @@
 package wpgx
 
 import (
+	"errors"
+	"sync"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
 
+// metricSet is the built-in MetricsRecorder, recording Prometheus metrics, and OpenTelemetry
+// metrics as well if otel is set.
 type metricSet struct {
 	AppName  string
 	ConnPool *prometheus.GaugeVec
-	Request  *prometheus.CounterVec
-	Latency  *prometheus.HistogramVec
-	Intent   *prometheus.CounterVec
-	Error    *prometheus.CounterVec
+	// ConnPoolCounter and AcquireDuration export the cumulative counters of pgxpool.Stat.
+	ConnPoolCounter *prometheus.CounterVec
+	AcquireDuration *prometheus.CounterVec
+	// AcquireLatency is the time that queries waited for a connection.
+	AcquireLatency *prometheus.HistogramVec
+	ReplicaLag     *prometheus.GaugeVec
+	Healthy        *prometheus.GaugeVec
+	Request        *prometheus.CounterVec
+	Latency        *prometheus.HistogramVec
+	FirstByte      *prometheus.HistogramVec
+	Intent         *prometheus.CounterVec
+	Error          *prometheus.CounterVec
+	NoRows         *prometheus.CounterVec
+	TxRetry        *prometheus.CounterVec
+
+	RowsReturned *prometheus.HistogramVec
+	RowsAffected *prometheus.HistogramVec
+	RowsCopied   *prometheus.HistogramVec
+
+	PostExecError   *prometheus.CounterVec
+	PostExecLatency *prometheus.HistogramVec
+
+	// otel, if set, records the observations as OpenTelemetry metrics as well.
+	otel *otelMetrics
+
+	// latencyUnit is the unit of latency histograms, time.Second unless using legacy milliseconds.
+	latencyUnit time.Duration
+
+	// registerer is the registerer that the collectors are registered to, and shared holds
+	// the collectors registered, or reused, by this metricSet, see Register.
+	registerer prometheus.Registerer
+	shared     []prometheus.Collector
+
+	// lastConnPoolStats is the last pgxpool.Stat of each pool, by replica label, used to
+	// compute the increments of the counters. Only accessed by Pool.updateMetrics.
+	lastConnPoolStats map[string]*pgxpool.Stat
 }
 
+var (
+	// collectorRefs counts the metricSets using each collector registered by a metricSet,
+	// the collector is unregistered when the last of them is unregistered. It is keyed by the
+	// collectors, pointers created by newMetricSet, rather than by registerers, which may not
+	// be comparable. A collector is only registered to the registerer of the metricSet that
+	// created it, and reused by metricSets of the same registerer.
+	collectorRefs      = make(map[prometheus.Collector]int)
+	collectorRefsMutex sync.Mutex
+)
+
 var (
 	labels        = []string{"app", "op", "replica"}
-	latencyBucket = []float64{
+	replicaLabels = []string{"app", "replica"}
+	retryLabels   = []string{"app", "replica", "code"}
+	// latencyBucket is in seconds, from 250us to 32s.
+	latencyBucket = prometheus.ExponentialBuckets(0.00025, 2, 18)
+	// acquiring an idle connection takes microseconds, so the buckets start lower.
+	acquireLatencyBucket = prometheus.ExponentialBuckets(0.0001, 2, 18)
+	// legacy buckets in milliseconds, see Config.LegacyMillisecondLatency.
+	legacyLatencyBucket = []float64{
 		4, 8, 16, 32, 64, 128, 256, 512, 1024, 2 * 1024, 4 * 1024, 8 * 1024, 16 * 1024, 32 * 1024}
+	legacyAcquireLatencyBucket = []float64{
+		0.1, 0.5, 1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2 * 1024, 4 * 1024, 8 * 1024}
+	rowsBucket             = prometheus.ExponentialBuckets(1, 4, 10)
 	connPoolUpdateInterval = 3 * time.Second
 )
 
-func newMetricSet(appName string) *metricSet {
+// reservedLabels are the variable labels of the metrics, which cannot be used as const labels.
+var reservedLabels = []string{"app", "op", "replica", "code"}
+
+// metricOptions are the options of the metrics of a Pool, see the Prometheus fields of Config.
+type metricOptions struct {
+	// constLabels are added to every metric, e.g. to tell apart pools of different databases
+	// in the same app.
+	constLabels prometheus.Labels
+	// latencyBuckets overrides the buckets of all latency histograms, in the unit of the histograms.
+	latencyBuckets     []float64
+	legacyMilliseconds bool
+	// native histograms are enabled when nativeHistogramBucketFactor > 1.
+	nativeHistogramBucketFactor    float64
+	nativeHistogramMaxBucketNumber uint32
+}
+
+func (o metricOptions) histogramOpts(name, help string, buckets []float64) prometheus.HistogramOpts {
+	opts := prometheus.HistogramOpts{
+		Name:        name,
+		Help:        help,
+		ConstLabels: o.constLabels,
+		Buckets:     buckets,
+	}
+	if o.nativeHistogramBucketFactor > 1 {
+		opts.NativeHistogramBucketFactor = o.nativeHistogramBucketFactor
+		opts.NativeHistogramMaxBucketNumber = o.nativeHistogramMaxBucketNumber
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+	return opts
+}
+
+func newMetricSet(appName string, opts metricOptions) *metricSet {
+	constLabels := opts.constLabels
+	latencyUnit, unitName := time.Second, "seconds"
+	latencyBuckets, acquireBuckets := latencyBucket, acquireLatencyBucket
+	if opts.legacyMilliseconds {
+		latencyUnit, unitName = time.Millisecond, "milliseconds"
+		latencyBuckets, acquireBuckets = legacyLatencyBucket, legacyAcquireLatencyBucket
+	}
+	if len(opts.latencyBuckets) > 0 {
+		latencyBuckets, acquireBuckets = opts.latencyBuckets, opts.latencyBuckets
+	}
 	return &metricSet{
-		AppName: appName,
+		AppName:     appName,
+		latencyUnit: latencyUnit,
 		ConnPool: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "wpgx_conn_pool",
-				Help: "connection pool status",
+				Name:        "wpgx_conn_pool",
+				Help:        "connection pool status",
+				ConstLabels: constLabels,
+			}, labels),
+		ConnPoolCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "wpgx_conn_pool_total",
+				Help:        "cumulative connection pool counters, e.g. acquire, empty_acquire and new_conns.",
+				ConstLabels: constLabels,
 			}, labels),
+		AcquireDuration: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "wpgx_conn_pool_acquire_seconds_total",
+				Help:        "total time spent acquiring connections from the pool in seconds.",
+				ConstLabels: constLabels,
+			}, replicaLabels),
+		AcquireLatency: prometheus.NewHistogramVec(
+			opts.histogramOpts(
+				"wpgx_acquire_latency_"+unitName,
+				"time spent waiting for a connection from the pool in "+unitName,
+				acquireBuckets), labels),
+		ReplicaLag: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "wpgx_replica_lag_seconds",
+				Help:        "replication lag of read replicas in seconds.",
+				ConstLabels: constLabels,
+			}, replicaLabels),
+		Healthy: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "wpgx_replica_healthy",
+				Help:        "1 if the read replica passes health checks, 0 if it is marked as broken.",
+				ConstLabels: constLabels,
+			}, replicaLabels),
 		Request: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "wpgx_request_total",
-				Help: "how many CRUD operations sent to DB.",
+				Name:        "wpgx_request_total",
+				Help:        "how many CRUD operations sent to DB.",
+				ConstLabels: constLabels,
 			}, labels),
 		Latency: prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name:    "wpgx_latency_milliseconds",
-				Help:    "CRUD latency in milliseconds",
-				Buckets: latencyBucket,
-			}, labels),
+			opts.histogramOpts(
+				"wpgx_latency_"+unitName,
+				"CRUD latency in "+unitName,
+				latencyBuckets), labels),
+		FirstByte: prometheus.NewHistogramVec(
+			opts.histogramOpts(
+				"wpgx_time_to_first_byte_"+unitName,
+				"latency until the query returned, before rows are read, in "+unitName,
+				latencyBuckets), labels),
 		Intent: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "wpgx_intent_total",
-				Help: "how many intent queries invoked, should be the sum of cached + hit_db.",
+				Name:        "wpgx_intent_total",
+				Help:        "how many intent queries invoked, should be the sum of cached + hit_db.",
+				ConstLabels: constLabels,
 			}, labels),
 		Error: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "wpgx_error_total",
-				Help: "how many errors were generated for this app and op.",
+				Name:        "wpgx_error_total",
+				Help:        "how many errors were generated for this app and op.",
+				ConstLabels: constLabels,
+			}, labels),
+		NoRows: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "wpgx_no_rows_total",
+				Help:        "how many queries of this app and op returned no rows, not counted as errors.",
+				ConstLabels: constLabels,
 			}, labels),
+		TxRetry: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "wpgx_tx_retry_total",
+				Help:        "how many times transactions were retried, by the SQLSTATE of the failed attempt.",
+				ConstLabels: constLabels,
+			}, retryLabels),
+		RowsReturned: prometheus.NewHistogramVec(
+			opts.histogramOpts(
+				"wpgx_rows_returned",
+				"number of rows returned by queries.",
+				rowsBucket), labels),
+		RowsAffected: prometheus.NewHistogramVec(
+			opts.histogramOpts(
+				"wpgx_rows_affected",
+				"number of rows affected by executions.",
+				rowsBucket), labels),
+		RowsCopied: prometheus.NewHistogramVec(
+			opts.histogramOpts(
+				"wpgx_rows_copied",
+				"number of rows copied by copyfrom.",
+				rowsBucket), labels),
+		PostExecError: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "wpgx_post_exec_error_total",
+				Help:        "how many post exec functions failed, by the op that registered them.",
+				ConstLabels: constLabels,
+			}, labels),
+		PostExecLatency: prometheus.NewHistogramVec(
+			opts.histogramOpts(
+				"wpgx_post_exec_latency_"+unitName,
+				"post exec function latency in "+unitName+", by the op that registered them.",
+				latencyBuckets), labels),
+		lastConnPoolStats: make(map[string]*pgxpool.Stat),
 	}
 }
 
-func (m *metricSet) Register() {
+// Register registers the collectors to @p registerer, or prometheus.DefaultRegisterer if nil.
+// If an identical collector is already registered, e.g. by another Pool of the same app,
+// the registered one is reused, so that both pools report to the same metrics.
+func (m *metricSet) Register(registerer prometheus.Registerer) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	m.registerer = registerer
+	collectorRefsMutex.Lock()
+	defer collectorRefsMutex.Unlock()
 	var failed []string
-	if err := prometheus.Register(m.ConnPool); err != nil {
+	if err := register(m, &m.ConnPool); err != nil {
 		failed = append(failed, "ConnPool gauges")
 	}
-	if err := prometheus.Register(m.Request); err != nil {
+	if err := register(m, &m.ConnPoolCounter); err != nil {
+		failed = append(failed, "ConnPoolCounter counters")
+	}
+	if err := register(m, &m.AcquireDuration); err != nil {
+		failed = append(failed, "AcquireDuration counters")
+	}
+	if err := register(m, &m.AcquireLatency); err != nil {
+		failed = append(failed, "AcquireLatency histogram")
+	}
+	if err := register(m, &m.ReplicaLag); err != nil {
+		failed = append(failed, "ReplicaLag gauges")
+	}
+	if err := register(m, &m.Healthy); err != nil {
+		failed = append(failed, "Healthy gauges")
+	}
+	if err := register(m, &m.Request); err != nil {
 		failed = append(failed, "Request counters")
 	}
-	if err := prometheus.Register(m.Latency); err != nil {
+	if err := register(m, &m.Latency); err != nil {
 		failed = append(failed, "Latency histogram")
 	}
-	if err := prometheus.Register(m.Intent); err != nil {
+	if err := register(m, &m.FirstByte); err != nil {
+		failed = append(failed, "FirstByte histogram")
+	}
+	if err := register(m, &m.Intent); err != nil {
 		failed = append(failed, "Intent counters")
 	}
-	if err := prometheus.Register(m.Error); err != nil {
+	if err := register(m, &m.Error); err != nil {
 		failed = append(failed, "Error counters")
 	}
+	if err := register(m, &m.NoRows); err != nil {
+		failed = append(failed, "NoRows counters")
+	}
+	if err := register(m, &m.TxRetry); err != nil {
+		failed = append(failed, "TxRetry counters")
+	}
+	if err := register(m, &m.RowsReturned); err != nil {
+		failed = append(failed, "RowsReturned histogram")
+	}
+	if err := register(m, &m.RowsAffected); err != nil {
+		failed = append(failed, "RowsAffected histogram")
+	}
+	if err := register(m, &m.RowsCopied); err != nil {
+		failed = append(failed, "RowsCopied histogram")
+	}
+	if err := register(m, &m.PostExecError); err != nil {
+		failed = append(failed, "PostExecError counters")
+	}
+	if err := register(m, &m.PostExecLatency); err != nil {
+		failed = append(failed, "PostExecLatency histogram")
+	}
 	if len(failed) > 0 {
 		log.Error().Msgf("failed to register Prometheus metrics: %v", failed)
 	}
 }
 
+// register registers the collector *c to m.registerer. If an identical collector is already
+// registered, *c is replaced by the existing one. Collectors registered by metricSets are
+// reference counted, collectorRefsMutex must be held.
+func register[T prometheus.Collector](m *metricSet, c *T) error {
+	err := m.registerer.Register(*c)
+	if err == nil {
+		m.retain(*c)
+		return nil
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(T); ok {
+			*c = existing
+			// collectors registered by others than metricSets are left to their owner.
+			if collectorRefs[existing] > 0 {
+				m.retain(existing)
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+func (m *metricSet) retain(c prometheus.Collector) {
+	collectorRefs[c]++
+	m.shared = append(m.shared, c)
+}
+
+// Unregister releases the collectors registered, or reused, by Register. A collector is
+// unregistered when no other metricSet, e.g. of another live Pool, uses it.
 func (m *metricSet) Unregister() {
-	prometheus.Unregister(m.ConnPool)
-	prometheus.Unregister(m.Request)
-	prometheus.Unregister(m.Latency)
-	prometheus.Unregister(m.Intent)
-	prometheus.Unregister(m.Error)
+	if m.otel != nil {
+		m.otel.Unregister()
+	}
+	if m.registerer == nil {
+		return
+	}
+	collectorRefsMutex.Lock()
+	defer collectorRefsMutex.Unlock()
+	for _, c := range m.shared {
+		collectorRefs[c]--
+		if collectorRefs[c] <= 0 {
+			delete(collectorRefs, c)
+			m.registerer.Unregister(c)
+		}
+	}
+	m.shared = nil
 }
 
 func (s *metricSet) MakeObserver(name string, replicaName *ReplicaName, startedAt time.Time, errPtr *error) func() {
@@ -91,13 +355,73 @@ func (s *metricSet) MakeObserver(name string, replicaName *ReplicaName, startedA
 		if s.Request != nil {
 			s.Request.WithLabelValues(s.AppName, name, toLabel(replicaName)).Inc()
 		}
-		if s.Error != nil && errPtr != nil && *errPtr != nil {
-			s.Error.WithLabelValues(s.AppName, name, toLabel(replicaName)).Inc()
+		if errPtr != nil && *errPtr != nil {
+			if errors.Is(*errPtr, pgx.ErrNoRows) {
+				if s.NoRows != nil {
+					s.NoRows.WithLabelValues(s.AppName, name, toLabel(replicaName)).Inc()
+				}
+			} else if s.Error != nil {
+				s.Error.WithLabelValues(s.AppName, name, toLabel(replicaName)).Inc()
+			}
 		}
 		if s.Latency != nil {
 			s.Latency.WithLabelValues(s.AppName, name, toLabel(replicaName)).Observe(
-				float64(time.Since(startedAt).Milliseconds()))
+				s.since(startedAt))
+		}
+		if s.otel != nil {
+			var err error
+			if errPtr != nil {
+				err = *errPtr
+			}
+			s.otel.ObserveOperation(name, replicaName, startedAt, err)
+		}
+	}
+}
+
+func (s *metricSet) ObserveFirstByte(name string, replicaName *ReplicaName, startedAt time.Time) {
+	if s.FirstByte != nil {
+		s.FirstByte.WithLabelValues(s.AppName, name, toLabel(replicaName)).Observe(
+			s.since(startedAt))
+	}
+}
+
+func (s *metricSet) ObserveRowsReturned(name string, replicaName *ReplicaName, n int64) {
+	if s.RowsReturned != nil {
+		s.RowsReturned.WithLabelValues(s.AppName, name, toLabel(replicaName)).Observe(float64(n))
+	}
+}
+
+func (s *metricSet) ObserveRowsAffected(name string, replicaName *ReplicaName, n int64) {
+	if s.RowsAffected != nil {
+		s.RowsAffected.WithLabelValues(s.AppName, name, toLabel(replicaName)).Observe(float64(n))
+	}
+}
+
+func (s *metricSet) ObserveRowsCopied(name string, replicaName *ReplicaName, n int64) {
+	if s.RowsCopied != nil {
+		s.RowsCopied.WithLabelValues(s.AppName, name, toLabel(replicaName)).Observe(float64(n))
+	}
+}
+
+func (s *metricSet) MakePostExecObserver(
+	name string, replicaName *ReplicaName, startedAt time.Time, errPtr *error) func() {
+	return func() {
+		if s.PostExecError != nil && errPtr != nil && *errPtr != nil {
+			s.PostExecError.WithLabelValues(s.AppName, name, toLabel(replicaName)).Inc()
 		}
+		if s.PostExecLatency != nil {
+			s.PostExecLatency.WithLabelValues(s.AppName, name, toLabel(replicaName)).Observe(
+				s.since(startedAt))
+		}
+	}
+}
+
+func (s *metricSet) CountError(name string, replicaName *ReplicaName) {
+	if s.Error != nil {
+		s.Error.WithLabelValues(s.AppName, name, toLabel(replicaName)).Inc()
+	}
+	if s.otel != nil {
+		s.otel.CountError(name, replicaName)
 	}
 }
 
@@ -105,18 +429,25 @@ func (s *metricSet) CountIntent(name string, replicaName *ReplicaName) {
 	if s.Intent != nil {
 		s.Intent.WithLabelValues(s.AppName, name, toLabel(replicaName)).Inc()
 	}
+	if s.otel != nil {
+		s.otel.CountIntent(name, replicaName)
+	}
 }
 
-type pgxPoolStat struct {
-	replicaName *ReplicaName
-	stats       *pgxpool.Stat
+func (s *metricSet) CountTxRetry(replicaName *ReplicaName, code string) {
+	if s.TxRetry != nil {
+		s.TxRetry.WithLabelValues(s.AppName, toLabel(replicaName), code).Inc()
+	}
 }
 
-func (s *metricSet) UpdateConnPoolGauge(statsList []pgxPoolStat) {
+// UpdateConnPoolGauge updates the gauges of the pools, and the counters,
+// see updateConnPoolCounters.
+func (s *metricSet) UpdateConnPoolGauge(statsList []PoolStat) {
+	s.updateConnPoolCounters(statsList)
 	if s.ConnPool != nil {
 		for _, poolStats := range statsList {
-			stats := poolStats.stats
-			replicaName := poolStats.replicaName
+			stats := poolStats.Stat
+			replicaName := poolStats.ReplicaName
 			s.ConnPool.WithLabelValues(s.AppName, "max_conns", toLabel(replicaName)).Set(float64(stats.MaxConns()))
 			s.ConnPool.WithLabelValues(s.AppName, "total_conns", toLabel(replicaName)).Set(float64(stats.TotalConns()))
 			s.ConnPool.WithLabelValues(s.AppName, "idle_conns", toLabel(replicaName)).Set(float64(stats.IdleConns()))
@@ -126,3 +457,89 @@ func (s *metricSet) UpdateConnPoolGauge(statsList []pgxPoolStat) {
 		}
 	}
 }
+
+// since returns the time elapsed since @p startedAt in the unit of latency histograms.
+func (s *metricSet) since(startedAt time.Time) float64 {
+	return float64(time.Since(startedAt)) / float64(s.latencyUnit)
+}
+
+// updateConnPoolCounters adds the increments of the cumulative counters of pgxpool.Stat since
+// the last update. Counters that went backwards, e.g. because the pool was re-created, are
+// incremented by their new value.
+func (s *metricSet) updateConnPoolCounters(statsList []PoolStat) {
+	if s.ConnPoolCounter == nil && s.AcquireDuration == nil {
+		return
+	}
+	for _, poolStats := range statsList {
+		stats := poolStats.Stat
+		replicaLabel := toLabel(poolStats.ReplicaName)
+		last := s.lastConnPoolStats[replicaLabel]
+		s.lastConnPoolStats[replicaLabel] = stats
+		if s.ConnPoolCounter != nil {
+			for _, c := range []struct {
+				op   string
+				curr func(*pgxpool.Stat) int64
+			}{
+				{"acquire", (*pgxpool.Stat).AcquireCount},
+				{"empty_acquire", (*pgxpool.Stat).EmptyAcquireCount},
+				{"canceled_acquire", (*pgxpool.Stat).CanceledAcquireCount},
+				{"new_conns", (*pgxpool.Stat).NewConnsCount},
+				{"max_lifetime_destroy", (*pgxpool.Stat).MaxLifetimeDestroyCount},
+				{"max_idle_destroy", (*pgxpool.Stat).MaxIdleDestroyCount},
+			} {
+				var prev int64
+				if last != nil {
+					prev = c.curr(last)
+				}
+				s.ConnPoolCounter.WithLabelValues(s.AppName, c.op, replicaLabel).Add(
+					float64(counterDelta(prev, c.curr(stats))))
+			}
+		}
+		if s.AcquireDuration != nil {
+			var prev time.Duration
+			if last != nil {
+				prev = last.AcquireDuration()
+			}
+			s.AcquireDuration.WithLabelValues(s.AppName, replicaLabel).Add(
+				time.Duration(counterDelta(int64(prev), int64(stats.AcquireDuration()))).Seconds())
+		}
+	}
+}
+
+// counterDelta returns the increment of a cumulative counter from @p prev to @p curr.
+func counterDelta(prev, curr int64) int64 {
+	if curr < prev {
+		return curr
+	}
+	return curr - prev
+}
+
+// ObserveAcquire observes the time that the query waited for a connection.
+func (s *metricSet) ObserveAcquire(name string, replicaName *ReplicaName, startedAt time.Time) {
+	if s.AcquireLatency != nil {
+		s.AcquireLatency.WithLabelValues(s.AppName, name, toLabel(replicaName)).Observe(
+			s.since(startedAt))
+	}
+}
+
+func (s *metricSet) UpdateReplicaLagGauge(replicaName *ReplicaName, lag time.Duration) {
+	if s.ReplicaLag != nil {
+		s.ReplicaLag.WithLabelValues(s.AppName, toLabel(replicaName)).Set(lag.Seconds())
+	}
+}
+
+func (s *metricSet) DeleteReplicaLagGauge(replicaName *ReplicaName) {
+	if s.ReplicaLag != nil {
+		s.ReplicaLag.DeleteLabelValues(s.AppName, toLabel(replicaName))
+	}
+}
+
+func (s *metricSet) UpdateReplicaHealthGauge(replicaName *ReplicaName, healthy bool) {
+	if s.Healthy != nil {
+		v := 0.0
+		if healthy {
+			v = 1
+		}
+		s.Healthy.WithLabelValues(s.AppName, toLabel(replicaName)).Set(v)
+	}
+}
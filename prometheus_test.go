@@ -0,0 +1,163 @@
+package wpgx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricSetTestSuite struct {
+	suite.Suite
+}
+
+func TestMetricSetTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricSetTestSuite))
+}
+
+func (suite *MetricSetTestSuite) TestRegisterReusesCollectors() {
+	registry := prometheus.NewRegistry()
+	first := newMetricSet("metric_test", metricOptions{})
+	first.Register(registry)
+	second := newMetricSet("metric_test", metricOptions{})
+	second.Register(registry)
+
+	// the second metricSet reports to the collectors of the first one.
+	suite.Same(first.Request, second.Request)
+	second.CountIntent("intent", nil)
+	suite.Equal(1.0, testutil.ToFloat64(first.Intent.WithLabelValues("metric_test", "intent", "primary")))
+
+	// collectors are unregistered when the last user is unregistered.
+	second.Unregister()
+	suite.Equal(1, testutil.CollectAndCount(registry, "wpgx_intent_total"))
+	first.Unregister()
+	suite.Equal(0, testutil.CollectAndCount(registry, "wpgx_intent_total"))
+}
+
+func (suite *MetricSetTestSuite) TestUnregisterOwnerFirst() {
+	registry := prometheus.NewRegistry()
+	first := newMetricSet("metric_test", metricOptions{})
+	first.Register(registry)
+	second := newMetricSet("metric_test", metricOptions{})
+	second.Register(registry)
+	second.CountIntent("intent", nil)
+
+	// the collectors registered by the first metricSet are still used by the second one.
+	first.Unregister()
+	families, err := registry.Gather()
+	suite.Require().NoError(err)
+	suite.NotEmpty(families)
+	suite.Equal(1, testutil.CollectAndCount(registry, "wpgx_intent_total"))
+	second.CountIntent("intent", nil)
+	suite.Equal(2.0, testutil.ToFloat64(second.Intent.WithLabelValues("metric_test", "intent", "primary")))
+
+	second.Unregister()
+	families, err = registry.Gather()
+	suite.Require().NoError(err)
+	suite.Empty(families)
+
+	// collectors registered by others are never unregistered.
+	registry = prometheus.NewRegistry()
+	external := newMetricSet("metric_test", metricOptions{})
+	suite.Require().NoError(registry.Register(external.Intent))
+	reusing := newMetricSet("metric_test", metricOptions{})
+	reusing.Register(registry)
+	suite.Same(external.Intent, reusing.Intent)
+	reusing.Unregister()
+	suite.Equal(0, testutil.CollectAndCount(registry, "wpgx_intent_total"))
+	external.Intent.WithLabelValues("metric_test", "intent", "primary").Inc()
+	suite.Equal(1, testutil.CollectAndCount(registry, "wpgx_intent_total"))
+}
+
+// valueRegisterer is a Registerer that cannot be compared, as a struct value holding a slice.
+type valueRegisterer struct {
+	*prometheus.Registry
+	tags []string
+}
+
+func (suite *MetricSetTestSuite) TestNonComparableRegisterer() {
+	registry := prometheus.NewRegistry()
+	first := newMetricSet("metric_test", metricOptions{})
+	suite.NotPanics(func() { first.Register(valueRegisterer{Registry: registry, tags: []string{"first"}}) })
+	second := newMetricSet("metric_test", metricOptions{})
+	suite.NotPanics(func() { second.Register(valueRegisterer{Registry: registry, tags: []string{"second"}}) })
+	suite.Same(first.Intent, second.Intent)
+	second.CountIntent("intent", nil)
+
+	suite.NotPanics(first.Unregister)
+	suite.Equal(1, testutil.CollectAndCount(registry, "wpgx_intent_total"))
+	suite.NotPanics(second.Unregister)
+	suite.Equal(0, testutil.CollectAndCount(registry, "wpgx_intent_total"))
+}
+
+func (suite *MetricSetTestSuite) TestConstLabels() {
+	registry := prometheus.NewRegistry()
+	orders := newMetricSet("metric_test", metricOptions{constLabels: prometheus.Labels{"db": "orders"}})
+	orders.Register(registry)
+	users := newMetricSet("metric_test", metricOptions{constLabels: prometheus.Labels{"db": "users"}})
+	users.Register(registry)
+	suite.NotSame(orders.Request, users.Request)
+
+	orders.CountIntent("intent", nil)
+	users.CountIntent("intent", nil)
+	users.CountIntent("intent", nil)
+	suite.Equal(1.0, testutil.ToFloat64(orders.Intent.WithLabelValues("metric_test", "intent", "primary")))
+	suite.Equal(2.0, testutil.ToFloat64(users.Intent.WithLabelValues("metric_test", "intent", "primary")))
+	suite.Equal(2, testutil.CollectAndCount(registry, "wpgx_intent_total"))
+}
+
+func (suite *MetricSetTestSuite) TestLatencyUnit() {
+	startedAt := time.Now().Add(-1500 * time.Microsecond)
+
+	seconds := newMetricSet("metric_test", metricOptions{})
+	seconds.MakeObserver("op", nil, startedAt, nil)()
+	_, sum := histogramOf(suite.T(), seconds.Latency.WithLabelValues("metric_test", "op", "primary"))
+	suite.InDelta(0.0015, sum, 0.001)
+
+	// sub-millisecond latencies are not truncated in legacy mode either.
+	legacy := newMetricSet("metric_test", metricOptions{legacyMilliseconds: true})
+	legacy.MakeObserver("op", nil, startedAt, nil)()
+	_, sum = histogramOf(suite.T(), legacy.Latency.WithLabelValues("metric_test", "op", "primary"))
+	suite.InDelta(1.5, sum, 1)
+	suite.Equal(1, testutil.CollectAndCount(legacy.Latency, "wpgx_latency_milliseconds"))
+}
+
+func (suite *MetricSetTestSuite) TestHistogramOptions() {
+	stats := newMetricSet("metric_test", metricOptions{
+		latencyBuckets:                 []float64{0.001, 0.01},
+		nativeHistogramBucketFactor:    1.1,
+		nativeHistogramMaxBucketNumber: 100,
+	})
+	stats.MakeObserver("op", nil, time.Now(), nil)()
+	m := &dto.Metric{}
+	suite.Require().NoError(stats.Latency.WithLabelValues("metric_test", "op", "primary").(prometheus.Metric).Write(m))
+	suite.Len(m.GetHistogram().GetBucket(), 2)
+	suite.NotZero(m.GetHistogram().GetSchema())
+}
+
+func (suite *MetricSetTestSuite) TestCloseOwningPoolFirst() {
+	registry := prometheus.NewRegistry()
+	newPool := func() *Pool {
+		config := unreachableConfig()
+		config.AppName = "metric_test"
+		config.EnablePrometheus = true
+		config.PrometheusRegisterer = registry
+		pool, err := NewPool(context.Background(), config)
+		suite.Require().NoError(err)
+		return pool
+	}
+	a := newPool()
+	b := newPool()
+	defer b.Close()
+	b.WConn().CountIntent("intent")
+
+	a.Close()
+	// b still reports to the collectors registered by a.
+	b.WConn().CountIntent("intent")
+	suite.Equal(1, testutil.CollectAndCount(registry, "wpgx_intent_total"))
+	suite.Equal(2.0, testutil.ToFloat64(b.stats.(*metricSet).Intent.WithLabelValues("metric_test", "intent", "primary")))
+}
@@ -0,0 +1,65 @@
+package wpgx
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// RedactedArg is the value recorded in spans for redacted arguments.
+	RedactedArg = "<redacted>"
+
+	// argMaxLength is the maximum length of argument values recorded in spans.
+	argMaxLength = 256
+	// truncatedSuffix is appended to truncated SQL text and argument values.
+	truncatedSuffix = "..."
+)
+
+// ArgRedactor returns the value of the @p i-th (0-based) argument of a query to be recorded in
+// spans, e.g. RedactedArg to mask it, see Config.TraceArgs.
+type ArgRedactor func(i int, arg any) any
+
+// RedactArgsAt masks the arguments at @p positions, 0-based, e.g. the password of a login query.
+func RedactArgsAt(positions ...int) ArgRedactor {
+	return func(i int, arg any) any {
+		if slices.Contains(positions, i) {
+			return RedactedArg
+		}
+		return arg
+	}
+}
+
+// RedactArgsOfType masks the arguments of the same type as one of @p examples,
+// e.g. RedactArgsOfType("", []byte(nil)) masks all strings and byte slices.
+func RedactArgsOfType(examples ...any) ArgRedactor {
+	types := make([]reflect.Type, 0, len(examples))
+	for _, example := range examples {
+		types = append(types, reflect.TypeOf(example))
+	}
+	return func(_ int, arg any) any {
+		if slices.Contains(types, reflect.TypeOf(arg)) {
+			return RedactedArg
+		}
+		return arg
+	}
+}
+
+// normalizeSQL collapses consecutive whitespaces of @p sql, including the ones in literals,
+// and truncates it to at most @p maxLength bytes, if positive.
+func normalizeSQL(sql string, maxLength int) string {
+	return truncate(strings.Join(strings.Fields(sql), " "), maxLength)
+}
+
+// truncate truncates @p s to at most @p maxLength bytes, without breaking UTF-8 characters.
+func truncate(s string, maxLength int) string {
+	if maxLength <= 0 || len(s) <= maxLength {
+		return s
+	}
+	end := max(maxLength-len(truncatedSuffix), 0)
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + truncatedSuffix
+}
@@ -0,0 +1,300 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// replicaLagQuery returns whether the instance is in recovery, the time since the commit of the
+// last transaction it replayed in seconds, and its replay LSN.
+// The receive LSN of a standby must not be used to tell if it has caught up: once its WAL
+// receiver disconnects, the receive LSN stops moving and replay catches up with it, although
+// the standby falls further behind the primary. The replay LSN is compared with the current
+// WAL LSN of the primary instead, see lagOf.
+const replicaLagQuery = `SELECT pg_is_in_recovery(),
+  EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())::float8,
+  pg_last_wal_replay_lsn()::text`
+
+// unknownLag is the lag value of a replica whose lag has not been measured successfully.
+const unknownLag = int64(-1)
+
+// errLagUnknown is the error when a standby is behind the primary but has not replayed any
+// transaction, so its lag cannot be measured.
+var errLagUnknown = errors.New("standby is behind the primary without any replayed transaction")
+
+// monitorConn is a dedicated connection of the background checks of an instance, so that the
+// checks neither wait for nor fail because of a pool saturated by traffic. It is connected on
+// first use, and reconnected after any error.
+type monitorConn struct {
+	config *pgx.ConnConfig
+	mutex  sync.Mutex
+	conn   *pgx.Conn
+}
+
+// newMonitorConn returns a monitorConn connecting with the config of @p pool.
+func newMonitorConn(pool *pgxpool.Pool) *monitorConn {
+	return &monitorConn{config: pool.Config().ConnConfig}
+}
+
+// Do calls @p fn with the connection, connecting it first if needed.
+func (m *monitorConn) Do(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.conn == nil {
+		conn, err := pgx.ConnectConfig(ctx, m.config)
+		if err != nil {
+			return err
+		}
+		m.conn = conn
+	}
+	err := fn(m.conn)
+	if err != nil {
+		m.closeLocked()
+	}
+	return err
+}
+
+// Close closes the connection, if connected.
+func (m *monitorConn) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.closeLocked()
+}
+
+func (m *monitorConn) closeLocked() {
+	if m.conn == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = m.conn.Close(ctx)
+	m.conn = nil
+}
+
+// replica is a read replica that is not configured as broken.
+type replica struct {
+	name ReplicaName
+	pool *pgxpool.Pool
+	// monitor is the connection of the lag sampler and the health checker.
+	monitor *monitorConn
+	// lag is the last sampled replication lag in nanoseconds, unknownLag if unknown.
+	lag atomic.Int64
+	// replayLSN is the last known replay LSN, see ConsistencyToken.
+	replayLSN atomic.Uint64
+	// broken is set by the health checker when the replica fails to respond.
+	broken atomic.Bool
+
+	// consecutive ping results, only accessed by the health checker.
+	failures  int
+	successes int
+}
+
+func newReplica(name ReplicaName, pool *pgxpool.Pool) *replica {
+	r := &replica{name: name, pool: pool, monitor: newMonitorConn(pool)}
+	r.lag.Store(unknownLag)
+	return r
+}
+
+// Broken returns true if the replica has been marked as broken by the health checker.
+func (r *replica) Broken() bool {
+	return r.broken.Load()
+}
+
+// Lag returns the last sampled replication lag, ok is false when it is unknown.
+func (r *replica) Lag() (lag time.Duration, ok bool) {
+	v := r.lag.Load()
+	if v < 0 {
+		return 0, false
+	}
+	return time.Duration(v), true
+}
+
+// withinLag returns true if the replica is known to lag behind the primary no more than maxLag.
+// A non-positive maxLag means no limit.
+func (r *replica) withinLag(maxLag time.Duration) bool {
+	if maxLag <= 0 {
+		return true
+	}
+	lag, ok := r.Lag()
+	return ok && lag <= maxLag
+}
+
+// sampleLag samples the lag of the replica behind @p primaryLSN, the current WAL LSN of the
+// primary sampled right before.
+func (r *replica) sampleLag(ctx context.Context, primaryLSN ConsistencyToken) (time.Duration, error) {
+	var inRecovery bool
+	var seconds *float64
+	var replayLSN *string
+	err := r.monitor.Do(ctx, func(conn *pgx.Conn) error {
+		return conn.QueryRow(ctx, replicaLagQuery).Scan(&inRecovery, &seconds, &replayLSN)
+	})
+	if err != nil {
+		r.lag.Store(unknownLag)
+		return 0, err
+	}
+	if err := r.setReplayLSN(replayLSN); err != nil {
+		r.lag.Store(unknownLag)
+		return 0, err
+	}
+	lag, err := lagOf(inRecovery, seconds, ConsistencyToken(r.replayLSN.Load()), primaryLSN)
+	if err != nil {
+		r.lag.Store(unknownLag)
+		return 0, err
+	}
+	r.lag.Store(int64(lag))
+	return lag, nil
+}
+
+// lagOf returns the lag of an instance, whose last replayed transaction committed @p seconds ago,
+// nil if none, and whose replay LSN is @p replayLSN, behind the primary at @p primaryLSN.
+// Instances that are not in recovery (e.g. logical replicas) and standbys that have replayed the
+// WAL of the primary up to @p primaryLSN have zero lag.
+func lagOf(inRecovery bool, seconds *float64, replayLSN, primaryLSN ConsistencyToken) (time.Duration, error) {
+	if !inRecovery || replayLSN >= primaryLSN {
+		return 0, nil
+	}
+	if seconds == nil {
+		return 0, errLagUnknown
+	}
+	return time.Duration(math.Max(*seconds, 0) * float64(time.Second)), nil
+}
+
+// startLagMonitor starts monitorReplicaLag once the lag is used, i.e. Config.MaxReplicaLag or
+// a per-call maximum lag is set, unless it is disabled by Config.ReplicaLagCheckInterval,
+// already started, or the pool is closed.
+func (p *Pool) startLagMonitor() {
+	if p.lagCheckInterval <= 0 || len(p.replicas) == 0 {
+		return
+	}
+	p.lagMonitorMutex.Lock()
+	defer p.lagMonitorMutex.Unlock()
+	if p.lagMonitorStarted || p.ctx.Err() != nil {
+		return
+	}
+	p.lagMonitorStarted = true
+	p.wg.Add(1)
+	go p.monitorReplicaLag(p.lagCheckInterval)
+}
+
+// monitorReplicaLag periodically samples the replication lag of all replicas.
+func (p *Pool) monitorReplicaLag(interval time.Duration) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.sampleReplicasLag(interval)
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// sampleReplicasLag samples the current WAL LSN of the primary, and then the lag of all replicas
+// behind it. The lag of all replicas is unknown if the primary cannot be sampled.
+func (p *Pool) sampleReplicasLag(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+	var primaryLSN ConsistencyToken
+	err := p.monitor.Do(ctx, func(conn *pgx.Conn) error {
+		var lsn string
+		if err := conn.QueryRow(ctx, currentWalLSNQuery).Scan(&lsn); err != nil {
+			return err
+		}
+		var err error
+		primaryLSN, err = ParseConsistencyToken(lsn)
+		return err
+	})
+	for _, r := range p.replicas {
+		p.sampleReplicaLag(r, primaryLSN, err, timeout)
+	}
+}
+
+// sampleReplicaLag samples the lag of the replica behind @p primaryLSN, or marks it as unknown
+// if the primary could not be sampled, i.e. @p primaryErr is not nil.
+func (p *Pool) sampleReplicaLag(r *replica, primaryLSN ConsistencyToken, primaryErr error, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+	_, wasKnown := r.Lag()
+	var lag time.Duration
+	err := primaryErr
+	if err == nil {
+		lag, err = r.sampleLag(ctx, primaryLSN)
+	} else {
+		r.lag.Store(unknownLag)
+	}
+	if err != nil {
+		if p.ctx.Err() != nil {
+			return
+		}
+		if wasKnown {
+			log.Warn().Err(err).Msgf("failed to sample lag of replica %s", r.name)
+		}
+		if p.stats != nil {
+			p.stats.DeleteReplicaLagGauge(&r.name)
+		}
+		return
+	}
+	if p.stats != nil {
+		p.stats.UpdateReplicaLagGauge(&r.name, lag)
+	}
+}
+
+// checkReplicaHealth periodically pings all replicas, on their monitor connections. A replica is marked as broken after
+// @p failureThreshold consecutive failures, and restored after @p recoveryThreshold consecutive
+// successes.
+func (p *Pool) checkReplicaHealth(interval time.Duration, failureThreshold, recoveryThreshold int) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			return
+		}
+		for _, r := range p.replicas {
+			p.pingReplica(r, interval, failureThreshold, recoveryThreshold)
+		}
+	}
+}
+
+func (p *Pool) pingReplica(r *replica, timeout time.Duration, failureThreshold, recoveryThreshold int) {
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+	err := r.monitor.Do(ctx, func(conn *pgx.Conn) error {
+		return conn.Ping(ctx)
+	})
+	if p.ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		r.successes = 0
+		r.failures++
+		if !r.Broken() && r.failures >= failureThreshold {
+			r.broken.Store(true)
+			log.Error().Err(err).Msgf(
+				"replica %s failed %d consecutive health checks, marked as broken! Use primary instead.",
+				r.name, r.failures)
+		}
+	} else {
+		r.failures = 0
+		r.successes++
+		if r.Broken() && r.successes >= recoveryThreshold {
+			r.broken.Store(false)
+			log.Info().Msgf("replica %s recovered after %d consecutive health checks.", r.name, r.successes)
+		}
+	}
+	if p.stats != nil {
+		p.stats.UpdateReplicaHealthGauge(&r.name, !r.Broken())
+	}
+}
@@ -0,0 +1,100 @@
+package wpgx
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+type replicaGroupMember struct {
+	name   ReplicaName
+	weight int
+}
+
+// replicaGroup is a named group of replicas that serve the same data.
+type replicaGroup struct {
+	name     ReplicaGroupName
+	strategy LoadBalanceStrategy
+	members  []replicaGroupMember
+	next     atomic.Uint64
+}
+
+func newReplicaGroup(config *ReplicaGroupConfig, replicas []ReadReplicaConfig) *replicaGroup {
+	weights := make(map[ReplicaName]int)
+	for _, replica := range replicas {
+		weights[replica.Name] = max(replica.Weight, 1)
+	}
+	g := &replicaGroup{name: config.Name, strategy: config.Strategy}
+	for _, name := range config.Members {
+		g.members = append(g.members, replicaGroupMember{name: name, weight: weights[name]})
+	}
+	return g
+}
+
+// pick returns a member from @p available, indexes of g.members, according to the strategy.
+func (g *replicaGroup) pick(available []int, replicas map[ReplicaName]*replica) int {
+	switch g.strategy {
+	case LeastAcquiredConns:
+		best, bestConns := available[0], int32(-1)
+		for _, i := range available {
+			conns := replicas[g.members[i].name].pool.Stat().AcquiredConns()
+			if bestConns < 0 || conns < bestConns {
+				best, bestConns = i, conns
+			}
+		}
+		return best
+	case WeightedRandom:
+		total := 0
+		for _, i := range available {
+			total += g.members[i].weight
+		}
+		// #nosec G404 -- load balancing does not need a cryptographically secure random number.
+		n := rand.IntN(total)
+		for _, i := range available {
+			n -= g.members[i].weight
+			if n < 0 {
+				return i
+			}
+		}
+		return available[len(available)-1]
+	default:
+		return available[g.next.Add(1)%uint64(len(available))]
+	}
+}
+
+// WQuerierGroup returns a wrapped querier of a member of the replica group, picked by
+// the strategy of the group. Members that are broken, or lag behind the primary by more
+// than Config.MaxReplicaLag, are skipped. When no member is available, it returns the
+// primary connection.
+// When @p ctx carries a ConsistencyToken, members known to have caught up with it are preferred.
+func (p *Pool) WQuerierGroup(ctx context.Context, group ReplicaGroupName) (WQuerier, error) {
+	g, ok := p.replicaGroups[group]
+	if !ok {
+		return nil, fmt.Errorf("%w, name: %s", ErrReplicaGroupNotFound, group)
+	}
+	available := make([]int, 0, len(g.members))
+	for i, member := range g.members {
+		r, ok := p.replicas[member.name]
+		if !ok || r.Broken() || !r.withinLag(p.maxReplicaLag) {
+			continue
+		}
+		available = append(available, i)
+	}
+	if len(available) == 0 {
+		return p.WConn(), nil
+	}
+	if token, ok := ConsistencyTokenFromContext(ctx); ok {
+		p.startLagMonitor()
+		caughtUp := make([]int, 0, len(available))
+		for _, i := range available {
+			if p.replicas[g.members[i].name].caughtUp(token) {
+				caughtUp = append(caughtUp, i)
+			}
+		}
+		if len(caughtUp) > 0 {
+			available = caughtUp
+		}
+	}
+	return p.replicaWConn(p.replicas[g.members[g.pick(available, p.replicas)].name]), nil
+}
@@ -0,0 +1,84 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// SQLStateSerializationFailure is the SQLSTATE of serialization_failure.
+	SQLStateSerializationFailure = "40001"
+	// SQLStateDeadlockDetected is the SQLSTATE of deadlock_detected.
+	SQLStateDeadlockDetected = "40P01"
+)
+
+// RetryPolicy determines how Pool.TransactWithRetry retries a transaction.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the backoff before the first retry, doubled for each following retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper bound of the backoff, zero means no upper bound.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is a reasonable RetryPolicy for short OLTP transactions.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+}
+
+// backoff returns the jittered backoff before the next attempt, after @p attempt attempts failed.
+// The result is uniformly distributed in [b/2, b], where b is the exponential backoff.
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	b := r.InitialBackoff
+	for i := 1; i < attempt && (r.MaxBackoff <= 0 || b < r.MaxBackoff) && b <= math.MaxInt64/2; i++ {
+		b *= 2
+	}
+	if r.MaxBackoff > 0 && b > r.MaxBackoff {
+		b = r.MaxBackoff
+	}
+	if b <= 0 {
+		return 0
+	}
+	half := b / 2
+	// #nosec G404 -- jitter does not need a cryptographically secure random number.
+	return half + rand.N(b-half+1)
+}
+
+// sqlState returns the SQLSTATE of the error, or an empty string if it is not a PgError.
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// IsRetryableTxError returns true if the transaction failed with a serialization failure or
+// a deadlock, in which case re-running the whole transaction may succeed.
+func IsRetryableTxError(err error) bool {
+	switch sqlState(err) {
+	case SQLStateSerializationFailure, SQLStateDeadlockDetected:
+		return true
+	}
+	return false
+}
+
+// sleep waits for @p d or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,51 @@
+package wpgx
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/suite"
+)
+
+type RetryTestSuite struct {
+	suite.Suite
+}
+
+func TestRetryTestSuite(t *testing.T) {
+	suite.Run(t, new(RetryTestSuite))
+}
+
+func (suite *RetryTestSuite) TestIsRetryableTxError() {
+	suite.True(IsRetryableTxError(&pgconn.PgError{Code: SQLStateSerializationFailure}))
+	suite.True(IsRetryableTxError(fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: SQLStateDeadlockDetected})))
+	suite.False(IsRetryableTxError(&pgconn.PgError{Code: "23505"}))
+	suite.False(IsRetryableTxError(fmt.Errorf("not a pg error")))
+	suite.False(IsRetryableTxError(nil))
+}
+
+func (suite *RetryTestSuite) TestBackoff() {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		b := policy.backoff(1)
+		suite.GreaterOrEqual(b, 5*time.Millisecond)
+		suite.LessOrEqual(b, 10*time.Millisecond)
+		b = policy.backoff(2)
+		suite.GreaterOrEqual(b, 10*time.Millisecond)
+		suite.LessOrEqual(b, 20*time.Millisecond)
+		b = policy.backoff(8)
+		suite.GreaterOrEqual(b, 25*time.Millisecond)
+		suite.LessOrEqual(b, 50*time.Millisecond)
+	}
+	suite.Equal(time.Duration(0), RetryPolicy{}.backoff(3))
+
+	// zero MaxBackoff means no upper bound.
+	uncapped := RetryPolicy{MaxAttempts: 10, InitialBackoff: 10 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		b := uncapped.backoff(4)
+		suite.GreaterOrEqual(b, 40*time.Millisecond)
+		suite.LessOrEqual(b, 80*time.Millisecond)
+	}
+	suite.Positive(uncapped.backoff(100), "must not overflow")
+}
@@ -0,0 +1,158 @@
+package wpgx
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// errRow is a pgx.Row whose Scan returns err.
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...any) error {
+	return r.err
+}
+
+// errRows is a closed pgx.Rows whose Err is err, returned with err when a query fails, so that
+// reading or closing the rows is safe, as with pgx.
+type errRows struct {
+	err error
+}
+
+func (r errRows) Close()                                       {}
+func (r errRows) Err() error                                   { return r.err }
+func (r errRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r errRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r errRows) Next() bool                                   { return false }
+func (r errRows) Scan(dest ...any) error                       { return r.err }
+func (r errRows) Values() ([]any, error)                       { return nil, r.err }
+func (r errRows) RawValues() [][]byte                          { return nil }
+func (r errRows) Conn() *pgx.Conn                              { return nil }
+
+// observedRow is a pgx.Row that calls release, if set, and done with the error of Scan, once.
+type observedRow struct {
+	row     pgx.Row
+	release func()
+	done    func(err error)
+	once    sync.Once
+}
+
+func (r *observedRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	r.once.Do(func() {
+		if r.release != nil {
+			r.release()
+		}
+		r.done(err)
+	})
+	return err
+}
+
+// observeRow wraps the row so that the query is observed, logged, and its span is ended, when
+// the row is scanned, with the true result of the query. @p release, if not nil, is called
+// after the row is scanned, to release the connection of the row.
+func observeRow(
+	ctx context.Context, row pgx.Row, release func(), name, unprepared string,
+	stats MetricsRecorder, tracer *tracer, logger *queryLogger, replicaName *ReplicaName, startedAt time.Time,
+) pgx.Row {
+	return &observedRow{
+		row:     row,
+		release: release,
+		done: func(err error) {
+			if stats != nil {
+				stats.MakeObserver(name, replicaName, startedAt, &err)()
+			}
+			if logger != nil {
+				logger.Log(ctx, name, replicaName, unprepared, startedAt, -1, err)
+			}
+			if tracer != nil {
+				tracer.TraceEnd(ctx, &err)
+			}
+		},
+	}
+}
+
+// observedRows is a pgx.Rows that counts the rows read, and calls release, if set, and done
+// with the count and the error of the rows, once, when the rows are closed or exhausted.
+type observedRows struct {
+	pgx.Rows
+	n       int64
+	release func()
+	done    func(n int64, err error)
+	once    sync.Once
+}
+
+func (r *observedRows) Next() bool {
+	if r.Rows.Next() {
+		r.n++
+		return true
+	}
+	// pgx closes the rows when there are no more rows.
+	r.finish()
+	return false
+}
+
+func (r *observedRows) Close() {
+	r.Rows.Close()
+	r.finish()
+}
+
+func (r *observedRows) finish() {
+	r.once.Do(func() {
+		if r.release != nil {
+			r.release()
+		}
+		r.done(r.n, r.Rows.Err())
+	})
+}
+
+// observeRows wraps the rows returned by a query started at @p startedAt, so that the query is
+// observed, logged, and its span is ended, when the rows are closed, with the error of the rows.
+// The time until the query returned is observed as the time to first byte.
+// If the query failed, it is observed immediately and a closed errRows is returned.
+// @p release, if not nil, is called when the rows are closed or the query failed, to release
+// the connection of the rows.
+func observeRows(
+	ctx context.Context, rows pgx.Rows, err error, release func(), name, unprepared string,
+	stats MetricsRecorder, tracer *tracer, logger *queryLogger, replicaName *ReplicaName, startedAt time.Time,
+) (pgx.Rows, error) {
+	if err != nil {
+		if release != nil {
+			release()
+		}
+		if stats != nil {
+			stats.MakeObserver(name, replicaName, startedAt, &err)()
+		}
+		if logger != nil {
+			logger.Log(ctx, name, replicaName, unprepared, startedAt, -1, err)
+		}
+		if tracer != nil {
+			tracer.TraceEnd(ctx, &err)
+		}
+		return errRows{err: err}, err
+	}
+	if stats != nil {
+		stats.ObserveFirstByte(name, replicaName, startedAt)
+	}
+	return &observedRows{
+		Rows:    rows,
+		release: release,
+		done: func(n int64, err error) {
+			if stats != nil {
+				stats.MakeObserver(name, replicaName, startedAt, &err)()
+				stats.ObserveRowsReturned(name, replicaName, n)
+			}
+			if logger != nil {
+				logger.Log(ctx, name, replicaName, unprepared, startedAt, n, err)
+			}
+			if tracer != nil {
+				tracer.TraceEnd(ctx, &err)
+			}
+		},
+	}, nil
+}
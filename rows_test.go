@@ -0,0 +1,136 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeRows returns n rows, then err.
+type fakeRows struct {
+	pgx.Rows
+	n      int
+	err    error
+	closed bool
+}
+
+func (r *fakeRows) Next() bool {
+	if r.closed || r.n == 0 {
+		r.closed = true
+		return false
+	}
+	r.n--
+	return true
+}
+
+func (r *fakeRows) Close() {
+	r.closed = true
+}
+
+func (r *fakeRows) Err() error {
+	return r.err
+}
+
+type RowsTestSuite struct {
+	suite.Suite
+	stats *metricSet
+}
+
+func TestRowsTestSuite(t *testing.T) {
+	suite.Run(t, new(RowsTestSuite))
+}
+
+func (suite *RowsTestSuite) SetupTest() {
+	suite.stats = newMetricSet("rows_test", metricOptions{})
+}
+
+func (suite *RowsTestSuite) TestObserveRow() {
+	ctx := context.Background()
+	failed := errors.New("failed")
+	for name, err := range map[string]error{"ok": nil, "no_rows": pgx.ErrNoRows, "failed": failed} {
+		row := observeRow(ctx, errRow{err: err}, nil, name, "", suite.stats, nil, nil, nil, time.Now())
+		// nothing is observed until the row is scanned.
+		suite.Equal(0.0, testutil.ToFloat64(suite.stats.Request.WithLabelValues("rows_test", name, "primary")))
+		suite.Equal(err, row.Scan())
+		suite.Equal(err, row.Scan())
+		suite.Equal(1.0, testutil.ToFloat64(suite.stats.Request.WithLabelValues("rows_test", name, "primary")))
+	}
+	suite.Equal(0.0, testutil.ToFloat64(suite.stats.Error.WithLabelValues("rows_test", "ok", "primary")))
+	suite.Equal(0.0, testutil.ToFloat64(suite.stats.NoRows.WithLabelValues("rows_test", "ok", "primary")))
+	suite.Equal(0.0, testutil.ToFloat64(suite.stats.Error.WithLabelValues("rows_test", "no_rows", "primary")))
+	suite.Equal(1.0, testutil.ToFloat64(suite.stats.NoRows.WithLabelValues("rows_test", "no_rows", "primary")))
+	suite.Equal(1.0, testutil.ToFloat64(suite.stats.Error.WithLabelValues("rows_test", "failed", "primary")))
+	suite.Equal(0.0, testutil.ToFloat64(suite.stats.NoRows.WithLabelValues("rows_test", "failed", "primary")))
+}
+
+func (suite *RowsTestSuite) TestObserveRows() {
+	ctx := context.Background()
+	request := func(name string) float64 {
+		return testutil.ToFloat64(suite.stats.Request.WithLabelValues("rows_test", name, "primary"))
+	}
+
+	rows, err := observeRows(ctx, &fakeRows{n: 3}, nil, nil, "all", "", suite.stats, nil, nil, nil, time.Now())
+	suite.Require().NoError(err)
+	// the time to first byte is observed when the query returns, the query itself on close.
+	count, _ := histogramOf(suite.T(), suite.stats.FirstByte.WithLabelValues("rows_test", "all", "primary"))
+	suite.Equal(uint64(1), count)
+	suite.Equal(0.0, request("all"))
+	for rows.Next() {
+	}
+	suite.Equal(1.0, request("all"))
+	rows.Close()
+	suite.Equal(1.0, request("all"))
+
+	rows, err = observeRows(ctx, &fakeRows{n: 3}, nil, nil, "partial", "", suite.stats, nil, nil, nil, time.Now())
+	suite.Require().NoError(err)
+	suite.True(rows.Next())
+	rows.Close()
+	rows.Close()
+	suite.Equal(1.0, request("partial"))
+
+	// the error of the rows is observed.
+	failed := errors.New("failed")
+	rows, err = observeRows(ctx, &fakeRows{n: 1, err: failed}, nil, nil, "rows_err", "", suite.stats, nil, nil, nil, time.Now())
+	suite.Require().NoError(err)
+	for rows.Next() {
+	}
+	suite.Equal(failed, rows.Err())
+	suite.Equal(1.0, testutil.ToFloat64(suite.stats.Error.WithLabelValues("rows_test", "rows_err", "primary")))
+
+	// a failed query is observed immediately.
+	rows, err = observeRows(ctx, &fakeRows{}, failed, nil, "query_err", "", suite.stats, nil, nil, nil, time.Now())
+	suite.Equal(failed, err)
+	// as with pgx, the rows of a failed query are closed and safe to read.
+	suite.Require().NotNil(rows)
+	suite.False(rows.Next())
+	suite.Equal(failed, rows.Err())
+	rows.Close()
+	_, err = pgx.CollectRows(rows, pgx.RowTo[int])
+	suite.Equal(failed, err)
+	suite.Equal(1.0, request("query_err"))
+	suite.Equal(1.0, testutil.ToFloat64(suite.stats.Error.WithLabelValues("rows_test", "query_err", "primary")))
+	count, _ = histogramOf(suite.T(), suite.stats.FirstByte.WithLabelValues("rows_test", "query_err", "primary"))
+	suite.Equal(uint64(0), count)
+
+	count, sum := histogramOf(suite.T(), suite.stats.RowsReturned.WithLabelValues("rows_test", "all", "primary"))
+	suite.Equal(uint64(1), count)
+	suite.Equal(3.0, sum)
+	count, sum = histogramOf(suite.T(), suite.stats.RowsReturned.WithLabelValues("rows_test", "partial", "primary"))
+	suite.Equal(uint64(1), count)
+	suite.Equal(1.0, sum)
+}
+
+// histogramOf returns the sample count and sum of the histogram.
+func histogramOf(t *testing.T, o prometheus.Observer) (uint64, float64) {
+	m := &dto.Metric{}
+	require.NoError(t, o.(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
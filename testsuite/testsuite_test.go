@@ -3,9 +3,12 @@ package testsuite_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/stumble/wpgx"
@@ -317,6 +320,351 @@ func (suite *metaTestSuite) TestCopyFromUseGolden() {
 	suite.Golden("docs", dumper)
 }
 
+func (suite *metaTestSuite) TestGenericTransact() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	postExecCalled := false
+	doc, err := wpgx.Transact(ctx, suite.Pool, pgx.TxOptions{}, func(ctx context.Context, tx *wpgx.WTx) (*Doc, error) {
+		doc := &Doc{Id: 1, Rev: 1.5, Content: "typed", CreatedAt: time.Unix(1000, 0), Description: json.RawMessage("{}")}
+		_, err := tx.WExec(ctx, "insert_typed",
+			"INSERT INTO docs (id, rev, content, created_at, description) VALUES ($1,$2,$3,$4,$5)",
+			doc.Id, doc.Rev, doc.Content, doc.CreatedAt, doc.Description)
+		if err != nil {
+			return nil, err
+		}
+		return doc, tx.PostExec(func() error {
+			postExecCalled = true
+			return nil
+		})
+	})
+	suite.Require().NoError(err)
+	suite.Equal("typed", doc.Content)
+	suite.True(postExecCalled)
+
+	// the zero value is returned on errors, and nothing is committed.
+	count, err := wpgx.Transact(ctx, suite.Pool, pgx.TxOptions{}, func(ctx context.Context, tx *wpgx.WTx) (int, error) {
+		var count int
+		if err := tx.WQueryRow(ctx, "count_typed", "SELECT COUNT(*) FROM docs").Scan(&count); err != nil {
+			return 0, err
+		}
+		_, err := tx.WExec(ctx, "delete_typed", "DELETE FROM docs")
+		suite.Require().NoError(err)
+		return count, fmt.Errorf("abort")
+	})
+	suite.ErrorContains(err, "abort")
+	suite.Equal(0, count)
+	count, err = wpgx.TransactReplica(ctx, suite.Pool, nil, pgx.TxOptions{},
+		func(ctx context.Context, tx *wpgx.WRTx) (int, error) {
+			var count int
+			err := tx.WQueryRow(ctx, "count_typed", "SELECT COUNT(*) FROM docs").Scan(&count)
+			return count, err
+		})
+	suite.Require().NoError(err)
+	suite.Equal(1, count)
+}
+
+func (suite *metaTestSuite) TestTransactWithRetry() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	attempts := 0
+	postExecRuns := make([]int, 0)
+	policy := wpgx.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	resp, err := suite.Pool.TransactWithRetry(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable}, policy,
+		func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+			attempts++
+			attempt := attempts
+			_, err := tx.WExec(ctx, "insert_retry",
+				"INSERT INTO docs (id, rev, content, created_at, description) VALUES ($1,$2,$3,$4,$5)",
+				attempt, 1.0, "retry", time.Unix(1000, 0), json.RawMessage("{}"))
+			if err != nil {
+				return nil, err
+			}
+			suite.Require().NoError(tx.PostExec(func() error {
+				postExecRuns = append(postExecRuns, attempt)
+				return nil
+			}))
+			if attempt < 3 {
+				return nil, &pgconn.PgError{Code: wpgx.SQLStateSerializationFailure}
+			}
+			return attempt, nil
+		})
+	suite.Require().NoError(err)
+	suite.Equal(3, resp)
+	suite.Equal(3, attempts)
+	// PostExec functions of failed attempts are discarded.
+	suite.Equal([]int{3}, postExecRuns)
+	// only the last attempt is committed.
+	row := suite.Pool.WConn().WQueryRow(ctx, "count_retry", "SELECT COUNT(*), MIN(id) FROM docs")
+	var count, minID int
+	suite.Require().NoError(row.Scan(&count, &minID))
+	suite.Equal(1, count)
+	suite.Equal(3, minID)
+
+	// non-retryable errors and exhausted attempts are returned.
+	attempts = 0
+	_, err = suite.Pool.TransactWithRetry(ctx, pgx.TxOptions{}, policy,
+		func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+			attempts++
+			return nil, &pgconn.PgError{Code: wpgx.SQLStateDeadlockDetected}
+		})
+	suite.True(wpgx.IsRetryableTxError(err))
+	suite.Equal(3, attempts)
+}
+
+func (suite *metaTestSuite) TestNestedTransact() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	insert := func(ctx context.Context, tx *wpgx.WTx, id int) error {
+		_, err := tx.WExec(ctx, "insert_nested",
+			"INSERT INTO docs (id, rev, content, created_at, description) VALUES ($1,$2,$3,$4,$5)",
+			id, 1.0, "nested", time.Unix(1000, 0), json.RawMessage("{}"))
+		return err
+	}
+	postExecRuns := make([]string, 0)
+	postExec := func(name string) wpgx.PostExecFunc {
+		return func() error {
+			postExecRuns = append(postExecRuns, name)
+			return nil
+		}
+	}
+	_, err := suite.Pool.Transact(ctx, pgx.TxOptions{}, func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+		if err := insert(ctx, tx, 1); err != nil {
+			return nil, err
+		}
+		suite.Require().NoError(tx.PostExec(postExec("outer")))
+		// released savepoint.
+		resp, err := tx.Transact(ctx, func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+			suite.Require().NoError(tx.PostExec(postExec("released")))
+			return "ok", insert(ctx, tx, 2)
+		})
+		suite.Require().NoError(err)
+		suite.Equal("ok", resp)
+		// rolled back savepoint, duplicated key.
+		_, err = tx.Transact(ctx, func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+			suite.Require().NoError(tx.PostExec(postExec("rolled_back")))
+			if err := insert(ctx, tx, 3); err != nil {
+				return nil, err
+			}
+			return nil, insert(ctx, tx, 1)
+		})
+		suite.Require().Error(err)
+		// the outer transaction can continue.
+		return nil, insert(ctx, tx, 4)
+	})
+	suite.Require().NoError(err)
+	suite.ElementsMatch([]string{"outer", "released"}, postExecRuns)
+
+	rows, err := suite.Pool.WConn().WQuery(ctx, "select_nested", "SELECT id FROM docs ORDER BY id")
+	suite.Require().NoError(err)
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	suite.Require().NoError(err)
+	suite.Equal([]int{1, 2, 4}, ids)
+}
+
+func (suite *metaTestSuite) TestTransactWithToken() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, token, err := suite.Pool.TransactWithToken(ctx, pgx.TxOptions{},
+		func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+			_, err := tx.WExec(ctx, "insert_token",
+				"INSERT INTO docs (id, rev, content, created_at, description) VALUES ($1,$2,$3,$4,$5)",
+				1, 1.0, "token", time.Unix(1000, 0), json.RawMessage("{}"))
+			return "inserted", err
+		})
+	suite.Require().NoError(err)
+	suite.Equal("inserted", resp)
+	suite.NotZero(token)
+	current, err := suite.Pool.ConsistencyToken(ctx)
+	suite.Require().NoError(err)
+	suite.GreaterOrEqual(current, token)
+
+	// no token is returned if the transaction failed.
+	_, token, err = suite.Pool.TransactWithToken(ctx, pgx.TxOptions{},
+		func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+			return nil, fmt.Errorf("abort")
+		})
+	suite.ErrorContains(err, "abort")
+	suite.Zero(token)
+}
+
+func (suite *metaTestSuite) TestTransactReplica() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	loader := &loaderDumper{exec: suite.Pool.WConn()}
+	suite.LoadState("TestQueryUseLoader.docs.json", loader)
+
+	resp, err := wpgx.TransactReplica(ctx, suite.Pool, nil, pgx.TxOptions{IsoLevel: pgx.RepeatableRead},
+		func(ctx context.Context, tx *wpgx.WRTx) (string, error) {
+			var readOnly string
+			if err := tx.WQueryRow(ctx, "show_read_only", "SHOW transaction_read_only").Scan(&readOnly); err != nil {
+				return "", err
+			}
+			suite.Equal("on", readOnly)
+			var content string
+			err := tx.WQueryRow(ctx, "select_content", "SELECT content FROM docs WHERE id = $1", 33).Scan(&content)
+			return content, err
+		})
+	suite.Require().NoError(err)
+	suite.Equal("content read from file", resp)
+}
+
+func (suite *metaTestSuite) TestOnRollback() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var causes []error
+	onRollback := func(ctx context.Context, cause error) error {
+		causes = append(causes, cause)
+		return nil
+	}
+	// rolled back by an error.
+	errAbort := fmt.Errorf("abort")
+	_, err := suite.Pool.Transact(ctx, pgx.TxOptions{}, func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+		tx.OnRollback(ctx, onRollback)
+		return nil, errAbort
+	})
+	suite.ErrorIs(err, errAbort)
+	suite.Require().Len(causes, 1)
+	suite.ErrorIs(causes[0], errAbort)
+
+	// rolled back by a panic, which is propagated.
+	causes = nil
+	suite.Panics(func() {
+		_, _ = suite.Pool.Transact(ctx, pgx.TxOptions{}, func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+			tx.OnRollback(ctx, onRollback)
+			panic("boom")
+		})
+	})
+	suite.Require().Len(causes, 1)
+	suite.ErrorIs(causes[0], wpgx.ErrTxPanic)
+
+	// not called on commit, PostExecCtx is called instead.
+	causes = nil
+	postExecCalled := false
+	_, err = suite.Pool.Transact(ctx, pgx.TxOptions{}, func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+		tx.OnRollback(ctx, onRollback)
+		// a rolled back savepoint fires its own functions only.
+		_, err := tx.Transact(ctx, func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+			tx.OnRollback(ctx, onRollback)
+			return nil, errAbort
+		})
+		suite.ErrorIs(err, errAbort)
+		return nil, tx.PostExecCtx(ctx, func(ctx context.Context) error {
+			postExecCalled = true
+			return ctx.Err()
+		})
+	})
+	suite.Require().NoError(err)
+	suite.True(postExecCalled)
+	suite.Len(causes, 1)
+}
+
+func (suite *metaTestSuite) TestTransactPanic() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	insertAndPanic := func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+		_, err := tx.WExec(ctx, "insert_panic",
+			"INSERT INTO docs (id, rev, content, created_at, description) VALUES ($1,$2,$3,$4,$5)",
+			1, 1.0, "panic", time.Unix(1000, 0), json.RawMessage("{}"))
+		suite.Require().NoError(err)
+		panic("boom")
+	}
+	countDocs := func() int {
+		var count int
+		suite.Require().NoError(suite.Pool.WConn().WQueryRow(
+			ctx, "count_docs", "SELECT COUNT(*) FROM docs").Scan(&count))
+		return count
+	}
+
+	// re-panic by default.
+	suite.PanicsWithValue("boom", func() {
+		_, _ = suite.Pool.Transact(ctx, pgx.TxOptions{}, insertAndPanic)
+	})
+	suite.Equal(0, countDocs())
+
+	// recovered as *TxPanicError.
+	config := suite.GetConfig()
+	config.EnablePrometheus = false
+	config.RecoverTxPanic = true
+	pool, err := wpgx.NewPool(ctx, &config)
+	suite.Require().NoError(err)
+	defer pool.Close()
+	var resp any
+	suite.NotPanics(func() {
+		resp, err = pool.Transact(ctx, pgx.TxOptions{}, insertAndPanic)
+	})
+	suite.Nil(resp)
+	suite.ErrorIs(err, wpgx.ErrTxPanic)
+	var panicErr *wpgx.TxPanicError
+	suite.Require().ErrorAs(err, &panicErr)
+	suite.Equal("boom", panicErr.Value)
+	suite.NotEmpty(panicErr.Stack)
+	suite.Equal(0, countDocs())
+}
+
+func (suite *metaTestSuite) TestWSendBatch() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	run := func(conn wpgx.WBatcher) {
+		batch := &wpgx.WBatch{}
+		for i := 1; i <= 2; i++ {
+			batch.Queue("insert_batch",
+				"INSERT INTO docs (id, rev, content, created_at, description) VALUES ($1,$2,$3,$4,$5)",
+				i, 1.0, "batch", time.Unix(1000, 0), json.RawMessage("{}"))
+		}
+		batch.Queue("count_batch", "SELECT COUNT(*) FROM docs")
+		batch.Queue("delete_batch", "DELETE FROM docs")
+		results := conn.WSendBatch(ctx, "batch", batch)
+		for i := 0; i < 2; i++ {
+			cmd, err := results.Exec()
+			suite.Require().NoError(err)
+			suite.Equal(int64(1), cmd.RowsAffected())
+		}
+		var count int
+		suite.Require().NoError(results.QueryRow().Scan(&count))
+		suite.Equal(2, count)
+		suite.Require().NoError(results.Close())
+	}
+	run(suite.Pool.WConn())
+	_, err := suite.Pool.Transact(ctx, pgx.TxOptions{}, func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+		run(tx)
+		return nil, nil
+	})
+	suite.Require().NoError(err)
+}
+
+func (suite *metaTestSuite) TestInMemoryMetricsRecorder() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	recorder := wpgx.NewInMemoryMetricsRecorder()
+	config := suite.GetConfig()
+	config.MetricsRecorder = recorder
+	pool, err := wpgx.NewPool(ctx, &config)
+	suite.Require().NoError(err)
+	defer pool.Close()
+
+	_, err = pool.Transact(ctx, pgx.TxOptions{}, func(ctx context.Context, tx *wpgx.WTx) (any, error) {
+		_, err := tx.WExec(ctx, "insert_recorded",
+			"INSERT INTO docs (id, rev, content, created_at, description) VALUES ($1,$2,$3,$4,$5)",
+			1, 1.0, "recorded", time.Unix(1000, 0), json.RawMessage("{}"))
+		return nil, err
+	})
+	suite.Require().NoError(err)
+	var content string
+	err = pool.WConn().WQueryRow(ctx, "select_missing", "SELECT content FROM docs WHERE id = $1", 2).Scan(&content)
+	suite.ErrorIs(err, pgx.ErrNoRows)
+
+	suite.Equal([]string{"insert_recorded", "select_missing"}, recorder.Names())
+	suite.Equal(1, recorder.Count("insert_recorded"))
+	// no rows is not an error.
+	suite.Equal(0, recorder.Errors("select_missing"))
+}
+
 // TestGetRawPool tests GetRawPool() method
 func (suite *metaTestSuite) TestGetRawPool() {
 	rawPool := suite.GetRawPool()
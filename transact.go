@@ -0,0 +1,32 @@
+package wpgx
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Transact is the generic version of Pool.Transact, returning the response of @p fn
+// without type assertions. Tracing, metrics, and PostExec semantics are the same as Pool.Transact.
+func Transact[T any](
+	ctx context.Context, p *Pool, txOptions pgx.TxOptions, fn func(ctx context.Context, tx *WTx) (T, error),
+) (T, error) {
+	resp, err := p.Transact(ctx, txOptions, func(ctx context.Context, tx *WTx) (any, error) {
+		return fn(ctx, tx)
+	})
+	typed, _ := resp.(T)
+	return typed, err
+}
+
+// TransactReplica is the generic version of Pool.TransactReplica, returning the response of @p fn
+// without type assertions.
+func TransactReplica[T any](
+	ctx context.Context, p *Pool, name *ReplicaName, txOptions pgx.TxOptions,
+	fn func(ctx context.Context, tx *WRTx) (T, error),
+) (T, error) {
+	resp, err := p.TransactReplica(ctx, name, txOptions, func(ctx context.Context, tx *WRTx) (any, error) {
+		return fn(ctx, tx)
+	})
+	typed, _ := resp.(T)
+	return typed, err
+}
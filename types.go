@@ -6,6 +6,7 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -16,11 +17,86 @@ const (
 var (
 	// ErrReplicaNotFound is the error when the replica is not found.
 	ErrReplicaNotFound = fmt.Errorf("replica not found")
+	// ErrReplicaGroupNotFound is the error when the replica group is not found.
+	ErrReplicaGroupNotFound = fmt.Errorf("replica group not found")
+	// ErrPostExec is the error when PostExec functions failed after the transaction was committed,
+	// returned only if Config.PostExecReturnError is set.
+	ErrPostExec = fmt.Errorf("post exec failed")
+	// ErrTxPanic is the error when the body of a transaction panicked, returned as a *TxPanicError
+	// only if Config.RecoverTxPanic is set. Otherwise the panic propagates, and OnRollback
+	// functions are called with ErrTxPanic as the cause.
+	ErrTxPanic = fmt.Errorf("transaction panicked")
+	// ErrConsistencyToken is the error when the ConsistencyToken of a transaction could not be
+	// read after the transaction was committed, see Pool.TransactWithToken.
+	ErrConsistencyToken = fmt.Errorf("failed to read consistency token")
 )
 
+// TxPanicError is the error converted from a panic in the body of a transaction.
+type TxPanicError struct {
+	// Value is the value recovered from the panic.
+	Value any
+	// Stack is the stack trace of the goroutine when the panic was recovered.
+	Stack []byte
+}
+
+func (e *TxPanicError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrTxPanic, e.Value)
+}
+
+// Unwrap returns ErrTxPanic, and the recovered value if it is an error.
+func (e *TxPanicError) Unwrap() []error {
+	if err, ok := e.Value.(error); ok {
+		return []error{ErrTxPanic, err}
+	}
+	return []error{ErrTxPanic}
+}
+
 // ReplicaName is the name of the replica instance.
 type ReplicaName string
 
+// ReplicaGroupName is the name of a group of replica instances.
+type ReplicaGroupName string
+
+// LoadBalanceStrategy determines how a member of a replica group is picked.
+type LoadBalanceStrategy string
+
+const (
+	// RoundRobin picks members in turn. It is the default strategy.
+	RoundRobin LoadBalanceStrategy = "round_robin"
+	// LeastAcquiredConns picks the member with the least acquired connections.
+	LeastAcquiredConns LoadBalanceStrategy = "least_acquired_conns"
+	// WeightedRandom picks members randomly, proportional to ReadReplicaConfig.Weight.
+	WeightedRandom LoadBalanceStrategy = "weighted_random"
+)
+
+func (s LoadBalanceStrategy) valid() bool {
+	switch s {
+	case "", RoundRobin, LeastAcquiredConns, WeightedRandom:
+		return true
+	}
+	return false
+}
+
+// PostExecMode determines how PostExec functions of a transaction are run after commit.
+type PostExecMode string
+
+const (
+	// PostExecConcurrent runs every PostExec function in its own goroutine. It is the default mode.
+	PostExecConcurrent PostExecMode = "concurrent"
+	// PostExecSequential runs PostExec functions one by one, in registration order.
+	PostExecSequential PostExecMode = "sequential"
+	// PostExecBounded runs PostExec functions concurrently, by at most Config.PostExecConcurrency workers.
+	PostExecBounded PostExecMode = "bounded"
+)
+
+func (m PostExecMode) valid() bool {
+	switch m {
+	case "", PostExecConcurrent, PostExecSequential, PostExecBounded:
+		return true
+	}
+	return false
+}
+
 // toLabel is used to convert the replica name to a label.
 func toLabel(replicaName *ReplicaName) string {
 	if replicaName == nil {
@@ -30,14 +106,34 @@ func toLabel(replicaName *ReplicaName) string {
 }
 
 // PostExecFunc is the function that must be ran after a successful CRUD.
-// NOTE: context should have been captured into the function.
+// NOTE: context should have been captured into the function, or use PostExecCtxFunc.
 type PostExecFunc = func() error
 
+// PostExecCtxFunc is the context-aware PostExecFunc, called with the context given at registration,
+// which carries the trace span and cancellation of the transaction.
+type PostExecCtxFunc = func(ctx context.Context) error
+
+// OnRollbackFunc is the function that runs when a transaction is rolled back, e.g. to compensate
+// external side effects. @p cause is the error that aborted the transaction, nil if the
+// transaction is rolled back explicitly by WTx.Rollback. When the body panicked, it is the
+// *TxPanicError if Config.RecoverTxPanic is set, or ErrTxPanic while the panic propagates.
+type OnRollbackFunc = func(ctx context.Context, cause error) error
+
+// SpanNameFunc returns the name of the span of the query, or transaction, named @p queryName.
+type SpanNameFunc = func(ctx context.Context, queryName string) string
+
+// SpanAttributesFunc returns the attributes added to the span of the query, or transaction,
+// named @p queryName, e.g. the tenant id carried by the context.
+type SpanAttributesFunc = func(ctx context.Context, queryName string) []attribute.KeyValue
+
 // TxFunc is the body of a transaction.
 // ctx must be used to generate proper tracing spans.
 // If not, you might see incorrect parallel spans.
 type TxFunc = func(ctx context.Context, tx *WTx) (any, error)
 
+// ReadOnlyTxFunc is the body of a read-only transaction, see TxFunc.
+type ReadOnlyTxFunc = func(ctx context.Context, tx *WRTx) (any, error)
+
 // WQuerier is the abstraction of connections that are read-only.
 type WQuerier interface {
 	WQuery(
@@ -57,12 +153,29 @@ type WExecer interface {
 	PostExec(f PostExecFunc) error
 }
 
+// WPostExecCtxer is the abstraction of connections that support the context-aware PostExec,
+// implemented by WConn and WTx. It is not part of WExecer, so that existing implementations
+// of WExecer, e.g. fakes in tests, do not break.
+type WPostExecCtxer interface {
+	// PostExecCtx is the context-aware PostExec, @p f will be called with @p ctx.
+	PostExecCtx(ctx context.Context, f PostExecCtxFunc) error
+}
+
 // WCopyFromer is the abstraction of connections that can use PostgreSQL's copyfrom.
 type WCopyFromer interface {
 	WCopyFrom(
 		ctx context.Context, name string, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
 }
 
+// WBatcher is the abstraction of connections that can send a batch of queries in one round trip,
+// implemented by WConn and WTx. It is not part of WGConn, so that existing implementations of
+// WGConn do not break.
+type WBatcher interface {
+	// WSendBatch sends all queries of the batch. Every query is observed by its own name when its
+	// result is read, and the batch is traced as one span named @p name, ended by BatchResults.Close.
+	WSendBatch(ctx context.Context, name string, batch *WBatch) pgx.BatchResults
+}
+
 // WGConn is the abstraction over wrapped connections and transactions.
 type WGConn interface {
 	WQuerier
@@ -11,65 +11,169 @@ import (
 
 type WConn struct {
 	p           *pgxpool.Pool
-	stats       *metricSet
+	stats       MetricsRecorder
 	tracer      *tracer
+	logger      *queryLogger
 	replicaName *ReplicaName
+
+	// replica and primary are set for replica connections. Queries carrying a
+	// ConsistencyToken are sent to the primary if the replica has not caught up.
+	replica *replica
+	primary *pgxpool.Pool
+	// startLagMonitor starts the sampler of the replay LSN of replicas, see Pool.startLagMonitor.
+	startLagMonitor func()
 }
 
-var _ WGConn = (*WConn)(nil)
+var (
+	_ WGConn         = (*WConn)(nil)
+	_ WPostExecCtxer = (*WConn)(nil)
+	_ WBatcher       = (*WConn)(nil)
+)
 
-func (c *WConn) PostExec(fn PostExecFunc) error {
-	return fn()
+// route returns the pool and the replica name that the query should be sent to.
+func (c *WConn) route(ctx context.Context) (*pgxpool.Pool, *ReplicaName) {
+	if c.replica == nil {
+		return c.p, c.replicaName
+	}
+	token, ok := ConsistencyTokenFromContext(ctx)
+	if !ok {
+		return c.p, c.replicaName
+	}
+	c.startLagMonitor()
+	if c.replica.caughtUp(token) {
+		return c.p, c.replicaName
+	}
+	return c.primary, nil
 }
 
-func (c *WConn) WQuery(ctx context.Context, name string, unprepared string, args ...interface{}) (r pgx.Rows, err error) {
+// acquire acquires a connection from @p pp for the query named @p name, observing the time
+// spent waiting for it. @p ctx should carry the span of the query, so that the acquire span of
+// pgxTracer is its child.
+func (c *WConn) acquire(
+	ctx context.Context, pp *pgxpool.Pool, name string, replicaName *ReplicaName) (*pgxpool.Conn, error) {
+	startedAt := time.Now()
+	conn, err := pp.Acquire(ctx)
 	if c.stats != nil {
-		defer c.stats.MakeObserver(name, c.replicaName, time.Now(), &err)()
+		c.stats.ObserveAcquire(name, replicaName, startedAt)
 	}
+	return conn, err
+}
+
+func (c *WConn) PostExec(fn PostExecFunc) error {
+	return fn()
+}
+
+func (c *WConn) PostExecCtx(ctx context.Context, fn PostExecCtxFunc) error {
+	return fn(ctx)
+}
+
+// WQuery observes the query when the returned rows are closed, so rows must always be closed,
+// as required by pgx.
+func (c *WConn) WQuery(ctx context.Context, name string, unprepared string, args ...interface{}) (pgx.Rows, error) {
+	pp, replicaName := c.route(ctx)
+	startedAt := time.Now()
 	if c.tracer != nil {
-		ctx = c.tracer.TraceStart(ctx, name, c.replicaName)
-		defer c.tracer.TraceEnd(ctx, &err)
+		ctx = c.tracer.TraceStart(ctx, name, replicaName)
+		c.tracer.TraceSQL(ctx, unprepared, args)
 	}
-	r, err = c.p.Query(ctx, unprepared, args...)
-	return
+	conn, err := c.acquire(ctx, pp, name, replicaName)
+	if err != nil {
+		return observeRows(
+			ctx, nil, err, nil, name, unprepared, c.stats, c.tracer, c.logger, replicaName, startedAt)
+	}
+	r, err := conn.Query(ctx, unprepared, args...)
+	return observeRows(
+		ctx, r, err, conn.Release, name, unprepared, c.stats, c.tracer, c.logger, replicaName, startedAt)
 }
 
 func (c *WConn) WQueryRow(ctx context.Context, name string, unprepared string, args ...interface{}) pgx.Row {
-	if c.stats != nil {
-		defer c.stats.MakeObserver(name, c.replicaName, time.Now(), nil)()
-	}
+	pp, replicaName := c.route(ctx)
+	startedAt := time.Now()
 	if c.tracer != nil {
-		ctx = c.tracer.TraceStart(ctx, name, c.replicaName)
-		defer c.tracer.TraceEnd(ctx, nil)
+		ctx = c.tracer.TraceStart(ctx, name, replicaName)
+		c.tracer.TraceSQL(ctx, unprepared, args)
 	}
-	return c.p.QueryRow(ctx, unprepared, args...)
+	conn, err := c.acquire(ctx, pp, name, replicaName)
+	if err != nil {
+		return observeRow(
+			ctx, errRow{err: err}, nil, name, unprepared, c.stats, c.tracer, c.logger, replicaName, startedAt)
+	}
+	row := conn.QueryRow(ctx, unprepared, args...)
+	return observeRow(
+		ctx, row, conn.Release, name, unprepared, c.stats, c.tracer, c.logger, replicaName, startedAt)
 }
 
 func (c *WConn) WExec(ctx context.Context, name string, unprepared string, args ...interface{}) (cmd pgconn.CommandTag, err error) {
+	pp, replicaName := c.route(ctx)
 	if c.stats != nil {
-		defer c.stats.MakeObserver(name, c.replicaName, time.Now(), &err)()
+		defer c.stats.MakeObserver(name, replicaName, time.Now(), &err)()
+	}
+	if c.logger != nil {
+		defer func(startedAt time.Time) {
+			c.logger.Log(ctx, name, replicaName, unprepared, startedAt, cmd.RowsAffected(), err)
+		}(time.Now())
 	}
 	if c.tracer != nil {
-		ctx = c.tracer.TraceStart(ctx, name, c.replicaName)
+		ctx = c.tracer.TraceStart(ctx, name, replicaName)
+		c.tracer.TraceSQL(ctx, unprepared, args)
 		defer c.tracer.TraceEnd(ctx, &err)
 	}
-	cmd, err = c.p.Exec(ctx, unprepared, args...)
+	conn, err := c.acquire(ctx, pp, name, replicaName)
+	if err != nil {
+		return cmd, err
+	}
+	defer conn.Release()
+	cmd, err = conn.Exec(ctx, unprepared, args...)
+	if err == nil && c.stats != nil {
+		c.stats.ObserveRowsAffected(name, replicaName, cmd.RowsAffected())
+	}
 	return
 }
 
 func (c *WConn) WCopyFrom(
 	ctx context.Context, name string, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (n int64, err error) {
+	pp, replicaName := c.route(ctx)
 	if c.stats != nil {
-		defer c.stats.MakeObserver(name, c.replicaName, time.Now(), &err)()
+		defer c.stats.MakeObserver(name, replicaName, time.Now(), &err)()
+	}
+	if c.logger != nil {
+		defer func(startedAt time.Time) {
+			c.logger.Log(ctx, name, replicaName, copyFromSQL(tableName, columnNames), startedAt, n, err)
+		}(time.Now())
 	}
 	if c.tracer != nil {
-		ctx = c.tracer.TraceStart(ctx, name, c.replicaName)
+		ctx = c.tracer.TraceStart(ctx, name, replicaName)
 		defer c.tracer.TraceEnd(ctx, &err)
 	}
-	n, err = c.p.CopyFrom(ctx, tableName, columnNames, rowSrc)
+	conn, err := c.acquire(ctx, pp, name, replicaName)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	n, err = conn.CopyFrom(ctx, tableName, columnNames, rowSrc)
+	if err == nil && c.stats != nil {
+		c.stats.ObserveRowsCopied(name, replicaName, n)
+	}
 	return
 }
 
+func (c *WConn) WSendBatch(ctx context.Context, name string, batch *WBatch) pgx.BatchResults {
+	pp, replicaName := c.route(ctx)
+	startedAt := time.Now()
+	if c.tracer != nil {
+		ctx = c.tracer.TraceStart(ctx, name, replicaName)
+	}
+	conn, err := c.acquire(ctx, pp, name, replicaName)
+	if err != nil {
+		return newWBatchResults(
+			ctx, errBatchResults{err: err}, batch, c.stats, c.tracer, c.logger, replicaName, startedAt)
+	}
+	results := newWBatchResults(
+		ctx, conn.SendBatch(ctx, &batch.batch), batch, c.stats, c.tracer, c.logger, replicaName, startedAt)
+	results.release = conn.Release
+	return results
+}
+
 func (c *WConn) CountIntent(name string) {
 	if c.stats != nil {
 		c.stats.CountIntent(name, c.replicaName)
@@ -2,111 +2,397 @@ package wpgx
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
 	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
 )
 
+const (
+	savepointTraceSpanName = "$SAVEPOINT$"
+	postExecTraceSpanName  = "$POST_EXEC$"
+	// unknownPostExecName is the name of PostExec functions registered before any mutation.
+	unknownPostExecName = "$UNKNOWN$"
+)
+
+// postExecConfig determines how PostExec functions are run, see Config.PostExecMode.
+type postExecConfig struct {
+	mode        PostExecMode
+	concurrency int
+	returnError bool
+}
+
+// postExecFunc is a PostExecCtxFunc with the name of the mutation that registered it.
+// ctx is nil for functions registered by PostExec.
+type postExecFunc struct {
+	name string
+	ctx  context.Context
+	fn   PostExecCtxFunc
+}
+
+// onRollbackFunc is an OnRollbackFunc with the context given at registration.
+type onRollbackFunc struct {
+	ctx context.Context
+	fn  OnRollbackFunc
+}
+
 // WTx is a wrapped pgx.Tx. The main reason is to overwrite the PostExec method that
 // run all of them until the transaction is successfully committed.
 type WTx struct {
 	tx            pgx.Tx
-	stats         *metricSet
+	stats         MetricsRecorder
 	tracer        *tracer
-	postExecFuncs []PostExecFunc
-	mutex         sync.Mutex
+	logger        *queryLogger
+	replicaName   *ReplicaName
+	postExec      postExecConfig
+	recoverPanic  bool
+	postExecFuncs []postExecFunc
+	onRollback    []onRollbackFunc
+	// lastExecName is the name of the last mutation, used to name PostExec functions.
+	lastExecName string
+	mutex        sync.Mutex
+	// parent is set for nested transactions (savepoints).
+	parent *WTx
+	// bodyReturned is set once the body of the transaction returns, so that finish can tell
+	// a panic without recovering it.
+	bodyReturned bool
 }
 
-var _ WGConn = (*WTx)(nil)
+var (
+	_ WGConn         = (*WTx)(nil)
+	_ WPostExecCtxer = (*WTx)(nil)
+	_ WBatcher       = (*WTx)(nil)
+)
 
-func (t *WTx) runPostExecFuncs() {
-	var wg sync.WaitGroup
-	for _, v := range t.postExecFuncs {
-		wg.Add(1)
-		go func(fn PostExecFunc) {
-			defer wg.Done()
-			err := fn()
-			if err != nil {
-				log.Err(err).Msgf("Failed to run post exec function")
-			}
-		}(v)
+// runPostExecFuncs runs all PostExec functions according to the PostExecMode, returns
+// their joined errors wrapped in ErrPostExec if postExec.returnError is set.
+func (t *WTx) runPostExecFuncs() error {
+	errs := make([]error, len(t.postExecFuncs))
+	switch t.postExec.mode {
+	case PostExecSequential:
+		for i, f := range t.postExecFuncs {
+			errs[i] = t.runPostExecFunc(f)
+		}
+	default:
+		var eg errgroup.Group
+		if t.postExec.mode == PostExecBounded {
+			eg.SetLimit(t.postExec.concurrency)
+		}
+		for i, f := range t.postExecFuncs {
+			eg.Go(func() error {
+				errs[i] = t.runPostExecFunc(f)
+				return nil
+			})
+		}
+		_ = eg.Wait()
 	}
-	wg.Wait()
+	err := errors.Join(errs...)
+	if err == nil || !t.postExec.returnError {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrPostExec, err)
 }
 
+func (t *WTx) runPostExecFunc(f postExecFunc) (err error) {
+	if t.stats != nil {
+		defer t.stats.MakePostExecObserver(f.name, t.replicaName, time.Now(), &err)()
+	}
+	// the transaction has been committed, panics must not escape, especially from
+	// the goroutines of the concurrent modes.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("post exec panicked: %v", r)
+			log.Err(err).Msgf("Failed to run post exec function of %s", f.name)
+		}
+	}()
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	} else if t.tracer != nil {
+		ctx = t.tracer.TraceStart(ctx, postExecTraceSpanName, t.replicaName)
+		defer t.tracer.TraceEnd(ctx, &err)
+	}
+	err = f.fn(ctx)
+	if err != nil {
+		log.Err(err).Msgf("Failed to run post exec function of %s", f.name)
+	}
+	return err
+}
+
+// PostExec registers a function to be run after the transaction is successfully committed.
+// The function is named after the last mutation (WExec or WCopyFrom) in metrics.
 func (t *WTx) PostExec(f PostExecFunc) error {
+	return t.addPostExecFunc(nil, func(context.Context) error { return f() })
+}
+
+// PostExecCtx is the context-aware PostExec. @p f will be called with @p ctx, wrapped in
+// a span under the transaction span if tracing is enabled.
+func (t *WTx) PostExecCtx(ctx context.Context, f PostExecCtxFunc) error {
+	return t.addPostExecFunc(ctx, f)
+}
+
+func (t *WTx) addPostExecFunc(ctx context.Context, f PostExecCtxFunc) error {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
-	t.postExecFuncs = append(t.postExecFuncs, f)
+	name := t.lastExecName
+	if name == "" {
+		name = unknownPostExecName
+	}
+	t.postExecFuncs = append(t.postExecFuncs, postExecFunc{name: name, ctx: ctx, fn: f})
 	return nil
 }
 
-func (t *WTx) WQuery(ctx context.Context, name string, unprepared string, args ...interface{}) (rows pgx.Rows, err error) {
-	if t.stats != nil {
-		defer t.stats.MakeObserver(name, nil, time.Now(), &err)()
+// OnRollback registers a function to be run when the transaction is rolled back, including
+// when the transaction body panics or the commit fails. The functions are called with @p ctx,
+// in the reverse order of registration. Functions registered in a nested transaction
+// run when the savepoint is rolled back, or are promoted to the parent if it is released.
+func (t *WTx) OnRollback(ctx context.Context, f OnRollbackFunc) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.onRollback = append(t.onRollback, onRollbackFunc{ctx: ctx, fn: f})
+}
+
+func (t *WTx) runOnRollbackFuncs(cause error) {
+	t.mutex.Lock()
+	funcs := t.onRollback
+	t.onRollback = nil
+	t.mutex.Unlock()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		if err := funcs[i].fn(funcs[i].ctx, cause); err != nil {
+			log.Err(err).Msgf("Failed to run on rollback function")
+		}
 	}
+}
+
+func (t *WTx) setLastExecName(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lastExecName = name
+}
+
+func (t *WTx) WQuery(ctx context.Context, name string, unprepared string, args ...interface{}) (pgx.Rows, error) {
+	startedAt := time.Now()
 	if t.tracer != nil {
-		ctx = t.tracer.TraceStart(ctx, name, nil)
-		defer t.tracer.TraceEnd(ctx, &err)
+		ctx = t.tracer.TraceStart(ctx, name, t.replicaName)
+		t.tracer.TraceSQL(ctx, unprepared, args)
 	}
-	rows, err = t.tx.Query(ctx, unprepared, args...)
-	return
+	rows, err := t.tx.Query(ctx, unprepared, args...)
+	return observeRows(
+		ctx, rows, err, nil, name, unprepared, t.stats, t.tracer, t.logger, t.replicaName, startedAt)
 }
 
 func (t *WTx) WQueryRow(ctx context.Context, name string, unprepared string, args ...interface{}) pgx.Row {
-	if t.stats != nil {
-		defer t.stats.MakeObserver(name, nil, time.Now(), nil)()
-	}
+	startedAt := time.Now()
 	if t.tracer != nil {
-		ctx = t.tracer.TraceStart(ctx, name, nil)
-		defer t.tracer.TraceEnd(ctx, nil)
+		ctx = t.tracer.TraceStart(ctx, name, t.replicaName)
+		t.tracer.TraceSQL(ctx, unprepared, args)
 	}
-	return t.tx.QueryRow(ctx, unprepared, args...)
+	row := t.tx.QueryRow(ctx, unprepared, args...)
+	return observeRow(
+		ctx, row, nil, name, unprepared, t.stats, t.tracer, t.logger, t.replicaName, startedAt)
 }
 
 func (t *WTx) WExec(ctx context.Context, name string, unprepared string, args ...interface{}) (cmd pgconn.CommandTag, err error) {
+	t.setLastExecName(name)
 	if t.stats != nil {
-		defer t.stats.MakeObserver(name, nil, time.Now(), &err)()
+		defer t.stats.MakeObserver(name, t.replicaName, time.Now(), &err)()
+	}
+	if t.logger != nil {
+		defer func(startedAt time.Time) {
+			t.logger.Log(ctx, name, t.replicaName, unprepared, startedAt, cmd.RowsAffected(), err)
+		}(time.Now())
 	}
 	if t.tracer != nil {
-		ctx = t.tracer.TraceStart(ctx, name, nil)
+		ctx = t.tracer.TraceStart(ctx, name, t.replicaName)
+		t.tracer.TraceSQL(ctx, unprepared, args)
 		defer t.tracer.TraceEnd(ctx, &err)
 	}
 	cmd, err = t.tx.Exec(ctx, unprepared, args...)
+	if err == nil && t.stats != nil {
+		t.stats.ObserveRowsAffected(name, t.replicaName, cmd.RowsAffected())
+	}
 	return
 }
 
 func (t *WTx) WCopyFrom(
 	ctx context.Context, name string, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (n int64, err error) {
+	t.setLastExecName(name)
 	if t.stats != nil {
-		defer t.stats.MakeObserver(name, nil, time.Now(), &err)()
+		defer t.stats.MakeObserver(name, t.replicaName, time.Now(), &err)()
+	}
+	if t.logger != nil {
+		defer func(startedAt time.Time) {
+			t.logger.Log(ctx, name, t.replicaName, copyFromSQL(tableName, columnNames), startedAt, n, err)
+		}(time.Now())
 	}
 	if t.tracer != nil {
-		ctx = t.tracer.TraceStart(ctx, name, nil)
+		ctx = t.tracer.TraceStart(ctx, name, t.replicaName)
 		defer t.tracer.TraceEnd(ctx, &err)
 	}
 	n, err = t.tx.CopyFrom(ctx, tableName, columnNames, rowSrc)
+	if err == nil && t.stats != nil {
+		t.stats.ObserveRowsCopied(name, t.replicaName, n)
+	}
 	return
 }
 
+func (t *WTx) WSendBatch(ctx context.Context, name string, batch *WBatch) pgx.BatchResults {
+	t.setLastExecName(name)
+	startedAt := time.Now()
+	if t.tracer != nil {
+		ctx = t.tracer.TraceStart(ctx, name, t.replicaName)
+	}
+	results := t.tx.SendBatch(ctx, &batch.batch)
+	return newWBatchResults(ctx, results, batch, t.stats, t.tracer, t.logger, t.replicaName, startedAt)
+}
+
 func (t *WTx) CountIntent(name string) {
 	if t.stats != nil {
-		t.stats.CountIntent(name, nil)
+		t.stats.CountIntent(name, t.replicaName)
 	}
 }
 
+// Transact runs @p fn in a nested transaction, using a savepoint. When @p fn returns an error,
+// only the savepoint is rolled back and the error is returned, the transaction can continue.
+// PostExec functions registered inside the nested transaction are dropped if the savepoint
+// is rolled back, and promoted to this transaction if the savepoint is released.
+func (t *WTx) Transact(ctx context.Context, fn TxFunc) (resp any, err error) {
+	if t.tracer != nil {
+		ctx = t.tracer.TraceStart(ctx, savepointTraceSpanName, t.replicaName)
+		defer t.tracer.TraceEnd(ctx, &err)
+	}
+	pgxTx, err := t.tx.Begin(ctx)
+	if err != nil {
+		t.countError(savepointTraceSpanName, err)
+		return nil, err
+	}
+	tx := &WTx{
+		tx:           pgxTx,
+		stats:        t.stats,
+		tracer:       t.tracer,
+		logger:       t.logger,
+		replicaName:  t.replicaName,
+		postExec:     t.postExec,
+		recoverPanic: t.recoverPanic,
+		parent:       t,
+	}
+	defer tx.finish(ctx, savepointTraceSpanName, &err)
+	resp, err = fn(ctx, tx)
+	tx.bodyReturned = true
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Rollback rolls back the transaction, OnRollback functions are called with a nil cause.
 func (t *WTx) Rollback(ctx context.Context) error {
-	return t.tx.Rollback(ctx)
+	err := t.tx.Rollback(ctx)
+	if !errors.Is(err, pgx.ErrTxClosed) {
+		t.runOnRollbackFuncs(nil)
+	}
+	return err
 }
 
+// finish must be deferred after the transaction, named @p name in metrics, begins, and
+// bodyReturned set once its body returns. It rolls back the transaction if it has not been
+// committed, and counts the transaction as failed if it returns an error. If the body panicked,
+// the panic is returned as *TxPanicError if recoverPanic is set. Otherwise it is not recovered
+// at all, so that it keeps propagating, with the stack of the body in crash reports, after the
+// rollback.
+func (t *WTx) finish(ctx context.Context, name string, errPtr *error) {
+	var r any
+	if t.recoverPanic {
+		r = recover()
+	} else if !t.bodyReturned {
+		err := ErrTxPanic
+		t.rollbackUnlessClosed(ctx, &err)
+		t.countError(name, err)
+		if t.tracer != nil {
+			t.tracer.RecordError(ctx, err)
+		}
+		return
+	}
+	if r == nil {
+		t.rollbackUnlessClosed(ctx, errPtr)
+		t.countError(name, *errPtr)
+		return
+	}
+	var err error = &TxPanicError{Value: r, Stack: debug.Stack()}
+	t.rollbackUnlessClosed(ctx, &err)
+	t.countError(name, err)
+	*errPtr = err
+}
+
+// countError counts the transaction named @p name as failed if @p err is not nil. Errors of
+// PostExec functions are not counted, the transaction has been committed.
+func (t *WTx) countError(name string, err error) {
+	if err != nil && !errors.Is(err, ErrPostExec) && t.stats != nil {
+		t.stats.CountError(name, t.replicaName)
+	}
+}
+
+// rollbackUnlessClosed rolls back the transaction if it has not been committed or rolled back,
+// the rollback error, if any, is joined to *errPtr. OnRollback functions run if the transaction
+// was open, with *errPtr as the cause.
+func (t *WTx) rollbackUnlessClosed(ctx context.Context, errPtr *error) {
+	rollbackErr := t.tx.Rollback(ctx)
+	if errors.Is(rollbackErr, pgx.ErrTxClosed) {
+		return
+	}
+	t.runOnRollbackFuncs(*errPtr)
+	if rollbackErr != nil {
+		*errPtr = fmt.Errorf("rollback error: %w, original error: %w", rollbackErr, *errPtr)
+	}
+}
+
+// Commit commits the transaction and runs the PostExec functions.
+// For nested transactions, it releases the savepoint and promotes the PostExec
+// functions to the parent transaction.
+// When Config.PostExecReturnError is set, errors of PostExec functions are returned wrapped
+// in ErrPostExec, the transaction has been committed in that case.
 func (t *WTx) Commit(ctx context.Context) error {
 	err := t.tx.Commit(ctx)
 	if err != nil {
+		t.runOnRollbackFuncs(err)
 		return err
 	}
-	t.runPostExecFuncs()
-	return nil
+	if t.parent != nil {
+		t.parent.mutex.Lock()
+		defer t.parent.mutex.Unlock()
+		t.parent.postExecFuncs = append(t.parent.postExecFuncs, t.postExecFuncs...)
+		t.parent.onRollback = append(t.parent.onRollback, t.onRollback...)
+		return nil
+	}
+	return t.runPostExecFuncs()
+}
+
+// WRTx is a read-only transaction, see Pool.TransactReplica.
+// It only implements WQuerier, so that mutations are rejected at compile time.
+type WRTx struct {
+	tx *WTx
+}
+
+var _ WQuerier = (*WRTx)(nil)
+
+func (t *WRTx) WQuery(ctx context.Context, name string, unprepared string, args ...interface{}) (pgx.Rows, error) {
+	return t.tx.WQuery(ctx, name, unprepared, args...)
+}
+
+func (t *WRTx) WQueryRow(ctx context.Context, name string, unprepared string, args ...interface{}) pgx.Row {
+	return t.tx.WQueryRow(ctx, name, unprepared, args...)
+}
+
+func (t *WRTx) CountIntent(name string) {
+	t.tx.CountIntent(name)
 }
@@ -0,0 +1,204 @@
+package wpgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+// WTxTestSuite tests the parts of WTx that do not need a database.
+type WTxTestSuite struct {
+	suite.Suite
+}
+
+func TestWTxTestSuite(t *testing.T) {
+	suite.Run(t, new(WTxTestSuite))
+}
+
+func (suite *WTxTestSuite) TestPostExecSequential() {
+	tx := &WTx{postExec: postExecConfig{mode: PostExecSequential}}
+	var order []int
+	for i := 0; i < 5; i++ {
+		suite.Require().NoError(tx.PostExec(func() error {
+			time.Sleep(time.Duration(5-i) * time.Millisecond)
+			order = append(order, i)
+			return nil
+		}))
+	}
+	suite.NoError(tx.runPostExecFuncs())
+	suite.Equal([]int{0, 1, 2, 3, 4}, order)
+}
+
+func (suite *WTxTestSuite) TestPostExecBounded() {
+	tx := &WTx{postExec: postExecConfig{mode: PostExecBounded, concurrency: 2}}
+	var running, maxRunning atomic.Int32
+	var mu sync.Mutex
+	ran := 0
+	for i := 0; i < 8; i++ {
+		suite.Require().NoError(tx.PostExec(func() error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			ran++
+			return nil
+		}))
+	}
+	suite.NoError(tx.runPostExecFuncs())
+	suite.Equal(8, ran)
+	suite.LessOrEqual(maxRunning.Load(), int32(2))
+}
+
+func (suite *WTxTestSuite) TestPostExecErrors() {
+	errA := errors.New("a")
+	errB := errors.New("b")
+	tx := &WTx{}
+	tx.setLastExecName("update_a")
+	suite.Require().NoError(tx.PostExec(func() error { return errA }))
+	tx.setLastExecName("update_b")
+	suite.Require().NoError(tx.PostExec(func() error { return errB }))
+	suite.Require().NoError(tx.PostExec(func() error { return nil }))
+	suite.Equal("update_a", tx.postExecFuncs[0].name)
+	suite.Equal("update_b", tx.postExecFuncs[1].name)
+
+	// errors are only logged by default.
+	suite.NoError(tx.runPostExecFuncs())
+
+	tx.postExec.returnError = true
+	err := tx.runPostExecFuncs()
+	suite.ErrorIs(err, ErrPostExec)
+	suite.ErrorIs(err, errA)
+	suite.ErrorIs(err, errB)
+}
+
+func (suite *WTxTestSuite) TestPostExecCtx() {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	tx := &WTx{}
+	var got any
+	suite.Require().NoError(tx.PostExecCtx(ctx, func(ctx context.Context) error {
+		got = ctx.Value(key{})
+		return nil
+	}))
+	suite.Nil(got)
+	suite.NoError(tx.runPostExecFuncs())
+	suite.Equal("value", got)
+}
+
+func (suite *WTxTestSuite) TestOnRollbackOrder() {
+	cause := errors.New("cause")
+	tx := &WTx{}
+	var order []int
+	for i := 0; i < 3; i++ {
+		tx.OnRollback(context.Background(), func(ctx context.Context, err error) error {
+			suite.Equal(cause, err)
+			order = append(order, i)
+			return nil
+		})
+	}
+	tx.runOnRollbackFuncs(cause)
+	suite.Equal([]int{2, 1, 0}, order)
+	// functions run at most once.
+	tx.runOnRollbackFuncs(cause)
+	suite.Equal([]int{2, 1, 0}, order)
+}
+
+func (suite *WTxTestSuite) TestTxPanicError() {
+	errBoom := errors.New("boom")
+	var err error = &TxPanicError{Value: errBoom}
+	suite.ErrorIs(err, ErrTxPanic)
+	suite.ErrorIs(err, errBoom)
+	suite.Equal("transaction panicked: boom", err.Error())
+
+	err = &TxPanicError{Value: 42}
+	suite.ErrorIs(err, ErrTxPanic)
+	var panicErr *TxPanicError
+	suite.Require().ErrorAs(err, &panicErr)
+	suite.Equal(42, panicErr.Value)
+}
+
+func (suite *WTxTestSuite) TestPostExecPanic() {
+	for _, mode := range []PostExecMode{PostExecConcurrent, PostExecSequential} {
+		tx := &WTx{postExec: postExecConfig{mode: mode, returnError: true}}
+		suite.Require().NoError(tx.PostExec(func() error { panic("boom") }))
+		var err error
+		suite.NotPanics(func() { err = tx.runPostExecFuncs() })
+		suite.ErrorIs(err, ErrPostExec)
+		suite.ErrorContains(err, "boom")
+	}
+}
+
+func (suite *WTxTestSuite) TestCountError() {
+	stats := newMetricSet("wtx_test", metricOptions{})
+	tx := &WTx{stats: stats}
+	tx.countError("tx", nil)
+	tx.countError("tx", errors.New("failed"))
+	// the transaction has been committed when PostExec functions fail.
+	tx.countError("tx", fmt.Errorf("%w: %w", ErrPostExec, errors.New("failed")))
+	suite.Equal(1.0, testutil.ToFloat64(stats.Error.WithLabelValues("wtx_test", "tx", "primary")))
+}
+
+// fakeTx is a pgx.Tx that can only be rolled back.
+type fakeTx struct {
+	pgx.Tx
+	rolledBack bool
+}
+
+func (t *fakeTx) Rollback(context.Context) error {
+	if t.rolledBack {
+		return pgx.ErrTxClosed
+	}
+	t.rolledBack = true
+	return nil
+}
+
+func (suite *WTxTestSuite) TestFinishPanic() {
+	pgxTx := &fakeTx{}
+	tx := &WTx{tx: pgxTx}
+	var cause error
+	tx.OnRollback(context.Background(), func(_ context.Context, err error) error {
+		cause = err
+		return nil
+	})
+	var stack string
+	func() {
+		defer func() {
+			stack = string(debug.Stack())
+			suite.Equal("boom", recover())
+		}()
+		var err error
+		defer tx.finish(context.Background(), "tx", &err)
+		panic("boom")
+	}()
+	suite.True(pgxTx.rolledBack)
+	suite.ErrorIs(cause, ErrTxPanic)
+	suite.NotContains(stack, "(*WTx).finish", "the panic must not be recovered and re-panicked")
+
+	// recovered as *TxPanicError.
+	tx = &WTx{tx: &fakeTx{}, recoverPanic: true}
+	var err error
+	suite.NotPanics(func() {
+		defer tx.finish(context.Background(), "tx", &err)
+		panic("boom")
+	})
+	var panicErr *TxPanicError
+	suite.Require().ErrorAs(err, &panicErr)
+	suite.Equal("boom", panicErr.Value)
+	suite.NotEmpty(panicErr.Stack)
+}